@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,8 +16,8 @@ func main() {
 	scriptPathT := strings.TrimSpace(tk.GetParam(argsT, 1, ""))
 
 	if scriptPathT == "" {
-		tk.Pl("no script file specified")
-		return
+		fmt.Fprintln(os.Stderr, "no script file specified")
+		os.Exit(1)
 	}
 
 	ifGoPathT := tk.IfSwitchExistsWhole(argsT, "-gopath")
@@ -31,17 +32,58 @@ func main() {
 	}
 
 	if tk.IsErrStr(scriptT) {
-		tk.Pl("failed to load script: %v", tk.GetErrStr(scriptT))
+		fmt.Fprintf(os.Stderr, "failed to load script: %v\n", tk.GetErrStr(scriptT))
+		os.Exit(1)
+	}
+
+	if tk.IfSwitchExistsWhole(argsT, "--help") {
+		codeT, errT := qxlang.Compile(scriptT)
+
+		if errT != nil {
+			fmt.Fprintln(os.Stderr, errT)
+			os.Exit(1)
+		}
+
+		helpT, ok := codeT.GetParamsHelp()
+
+		if !ok {
+			helpT = "Usage: qx <script> [args]\n"
+		}
+
+		fmt.Print(helpT)
+
 		return
 	}
 
-	if tk.IfSwitchExists(argsT, "-debug") {
-		qxlang.DebugG = true
+	debugT := tk.IfSwitchExists(argsT, "-debug")
 
+	if debugT {
 		tk.Pl("args: %v", argsT)
 	}
 
-	rsT := qxlang.RunCode(scriptT)
+	absPathT, errT := filepath.Abs(scriptPathT)
+
+	if errT != nil {
+		absPathT = scriptPathT
+	}
+
+	optsT := []string{"-scriptPath=" + absPathT}
+
+	if debugT {
+		optsT = append(optsT, "-debug")
+	}
+
+	rsT := qxlang.RunCode(scriptT, optsT...)
+
+	if tk.IsErrX(rsT) {
+		fmt.Fprintln(os.Stderr, tk.GetErrStrX(rsT))
+		os.Exit(1)
+	}
+
+	// an integer result(by exit or exitCode) in 0-255 is used as the exit code, others are printed as the other values
+	if nv, ok := rsT.(int); ok && nv >= 0 && nv <= 255 {
+		os.Exit(nv)
+	}
 
 	if rsT != nil && rsT != tk.Undefined {
 		tk.Pl("%v", rsT)
@@ -1,20 +1,34 @@
 package qxlang
 
 import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/topxeq/tk"
 )
 
 var VersionG string = "0.0.1"
-var DebugG = false
 
 // OpCodes starts
 
@@ -55,6 +69,122 @@ const (
 
 	OpNow
 	OpTimeSub
+
+	OpDrop
+
+	OpStrAdd
+	OpStrLen
+	OpTrim
+	OpToUpper
+	OpToLower
+	OpContains
+	OpStartsWith
+	OpEndsWith
+	OpIndexOf
+	OpReplace
+	OpSubStr
+	OpRepeat
+	OpPadLeft
+	OpPadRight
+	OpSplit
+	OpSplitLines
+	OpJoin
+
+	OpRegMatch
+	OpRegFind
+	OpRegFindAll
+	OpRegFindGroups
+	OpRegReplace
+	OpRegSplit
+
+	OpPr
+	OpPlErr
+	OpSpr
+
+	OpGetItem
+	OpGetMapItem
+
+	OpIsErr
+	OpGetErrStr
+
+	OpLoadText
+	OpSaveText
+	OpAppendText
+	OpLoadBytes
+	OpSaveBytes
+	OpFileExists
+	OpIsDir
+	OpFileInfo
+	OpListDir
+	OpRemoveFile
+	OpRenameFile
+	OpCopyFile
+	OpEnsureMakeDirs
+
+	OpGetGlobalVarValue
+	OpAssignGlobal
+	OpSeq
+
+	OpSystemCmd
+	OpTestByText
+
+	OpJoinPath
+	OpGetFileBase
+	OpGetFileExt
+	OpGetFileDir
+	OpAbsPath
+	OpRelPath
+	OpGetCurrentDir
+	OpSetCurrentDir
+	OpGetHomeDir
+	OpGetTempDir
+
+	OpSleep
+
+	OpGetEnv
+	OpSetEnv
+	OpRemoveEnv
+	OpGetEnvList
+	OpExpandEnv
+
+	OpSystemCmdEx
+
+	OpStartProcess
+	OpWaitProcess
+	OpKillProcess
+	OpReadProcessOutput
+	OpWriteProcessInput
+
+	OpPipe
+
+	OpTrap
+
+	OpExitCode
+
+	OpGetParam
+	OpGetSwitch
+	OpIfSwitchExists
+	OpParams
+
+	OpReadLine
+	OpReadAll
+	OpReadLines
+	OpGetInput
+
+	OpHostCall
+
+	OpCallGo
+
+	OpMethod
+	OpGetField
+	OpSetField
+
+	OpCheckPerm
+
+	OpGo
+	OpWait
+
+	OpRange
 )
 
 const OpNameListG = `
@@ -93,33 +223,453 @@ OpRet
 OpNow
 OpTimeSub
 
+OpDrop
+
+OpStrAdd
+OpStrLen
+OpTrim
+OpToUpper
+OpToLower
+OpContains
+OpStartsWith
+OpEndsWith
+OpIndexOf
+OpReplace
+OpSubStr
+OpRepeat
+OpPadLeft
+OpPadRight
+OpSplit
+OpSplitLines
+OpJoin
+
+OpRegMatch
+OpRegFind
+OpRegFindAll
+OpRegFindGroups
+OpRegReplace
+OpRegSplit
+
+OpPr
+OpPlErr
+OpSpr
+
+OpGetItem
+OpGetMapItem
+
+OpIsErr
+OpGetErrStr
+
+OpLoadText
+OpSaveText
+OpAppendText
+OpLoadBytes
+OpSaveBytes
+OpFileExists
+OpIsDir
+OpFileInfo
+OpListDir
+OpRemoveFile
+OpRenameFile
+OpCopyFile
+OpEnsureMakeDirs
+
+OpGetGlobalVarValue
+OpAssignGlobal
+OpSeq
+
+OpSystemCmd
+OpTestByText
+
+OpJoinPath
+OpGetFileBase
+OpGetFileExt
+OpGetFileDir
+OpAbsPath
+OpRelPath
+OpGetCurrentDir
+OpSetCurrentDir
+OpGetHomeDir
+OpGetTempDir
+
+OpSleep
+
+OpGetEnv
+OpSetEnv
+OpRemoveEnv
+OpGetEnvList
+OpExpandEnv
+
+OpSystemCmdEx
+
+OpStartProcess
+OpWaitProcess
+OpKillProcess
+OpReadProcessOutput
+OpWriteProcessInput
+
+OpPipe
+
+OpTrap
+
+OpExitCode
+
+OpGetParam
+OpGetSwitch
+OpIfSwitchExists
+OpParams
+
+OpReadLine
+OpReadAll
+OpReadLines
+OpGetInput
+
+OpHostCall
+
+OpCallGo
+
+OpMethod
+OpGetField
+OpSetField
+
+OpCheckPerm
+
+OpGo
+OpWait
+
+OpRange
+
 `
 
-var OpNameMapG map[int]string = nil
+// OpNameMapG is built once from OpNameListG while initializing the package, and should be read only
+var OpNameMapG map[int]string = newOpNameMap()
+
+func newOpNameMap() map[int]string {
+	listT := tk.SplitLines(OpNameListG)
+	mapT := make(map[int]string)
+	idxT := 0
+	for _, v := range listT {
+		v = strings.TrimSpace(v)
+
+		if v == "" {
+			continue
+		}
+
+		if strings.HasPrefix(v, "//") {
+			continue
+		}
+
+		mapT[idxT] = v
+
+		idxT++
+	}
+
+	return mapT
+}
 
 func (v OpCodeNum) String() string {
-	if OpNameMapG == nil {
-		listT := tk.SplitLines(OpNameListG)
-		OpNameMapG = make(map[int]string)
-		idxT := 0
-		for _, v := range listT {
-			v = strings.TrimSpace(v)
-
-			if v == "" {
-				continue
+	return fmt.Sprintf("%v", OpNameMapG[int(v)])
+}
+
+// permission related
+
+// Permission is the set of capabilities needed by the instructions, used to run the untrusted scripts in a sandbox
+type Permission int
+
+const (
+	PermExec      Permission = 1 << iota // run os commands and processes
+	PermEnv                              // read or write the os environment variables
+	PermFsRead                           // read files and directories
+	PermFsWrite                          // write, remove or rename files and directories, change the current directory
+	PermNetwork                          // access the network, for the instructions registered by the host application
+	PermClipboard                        // read or write the clipboard
+	PermSignal                           // receive the os signals by trap
+
+	PermNone Permission = 0
+	PermAll  Permission = PermExec | PermEnv | PermFsRead | PermFsWrite | PermNetwork | PermClipboard | PermSignal
+)
+
+var permissionNamesG = []struct {
+	Perm Permission
+	Name string
+}{
+	{PermExec, "exec"},
+	{PermEnv, "env"},
+	{PermFsRead, "fs-read"},
+	{PermFsWrite, "fs-write"},
+	{PermNetwork, "network"},
+	{PermClipboard, "clipboard"},
+	{PermSignal, "signal"},
+}
+
+func (v Permission) String() string {
+	listT := make([]string, 0, len(permissionNamesG))
+
+	for _, jv := range permissionNamesG {
+		if v&jv.Perm != 0 {
+			listT = append(listT, jv.Name)
+		}
+	}
+
+	return strings.Join(listT, ",")
+}
+
+// ParsePermissions parses the permission names separated by comma, such as "exec,fs-read", "all" and "none" are also accepted
+func ParsePermissions(strA string) (Permission, error) {
+	var rs Permission
+
+	for _, v := range strings.Split(strA, ",") {
+		v = strings.TrimSpace(v)
+
+		switch v {
+		case "", "none":
+			continue
+		case "all":
+			rs |= PermAll
+			continue
+		}
+
+		foundT := false
+
+		for _, jv := range permissionNamesG {
+			if jv.Name == v {
+				rs |= jv.Perm
+				foundT = true
+				break
 			}
+		}
 
-			if strings.HasPrefix(v, "//") {
-				continue
+		if !foundT {
+			return PermNone, fmt.Errorf("unknown permission: %v", v)
+		}
+	}
+
+	return rs, nil
+}
+
+// PermissionError is the error of the instruction not permitted, set to the result as an error value(use isErr to check) if the instruction has a result parameter, or raised as a runtime error otherwise
+type PermissionError struct {
+	Instr string
+	Perm  Permission // the denied permissions
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("permission denied for %v: %v", e.Instr, e.Perm)
+}
+
+// the permissions needed by the built-in instructions, all of them have the result parameter except the ones in instrNoResultSetG
+var instrPermMapG = map[int]Permission{
+	20505: PermSignal, // trap
+
+	20511: PermClipboard, // getClipText
+	20512: PermClipboard, // setClipText
+
+	20521: PermEnv, // getEnv
+	20522: PermEnv, // setEnv
+	20523: PermEnv, // removeEnv
+	20525: PermEnv, // getEnvList
+	20527: PermEnv, // expandEnv
+
+	20601: PermExec, // systemCmd
+	20603: PermExec, // systemCmdEx
+	20611: PermExec, // startProcess
+	20631: PermExec, // pipe
+
+	21101: PermFsRead,  // loadText
+	21103: PermFsWrite, // saveText
+	21105: PermFsWrite, // appendText
+	21111: PermFsRead,  // loadBytes
+	21113: PermFsWrite, // saveBytes
+
+	21201: PermFsRead, // fileExists
+	21203: PermFsRead, // isDir
+	21205: PermFsRead, // fileInfo
+	21211: PermFsRead, // listDir
+
+	21301: PermFsWrite,              // removeFile
+	21303: PermFsWrite,              // renameFile
+	21305: PermFsRead | PermFsWrite, // copyFile
+	21311: PermFsWrite,              // ensureMakeDirs
+
+	21911: PermFsRead,  // absPath
+	21921: PermFsRead,  // getCurrentDir
+	21922: PermFsWrite, // setCurrentDir
+	21925: PermFsRead,  // getHomeDir
+	21927: PermFsRead,  // getTempDir
+}
+
+// the instructions in instrPermMapG without the result parameter, the denied error is raised as a runtime error
+var instrNoResultSetG = map[int]bool{
+	20505: true, // trap
+}
+
+// getInstrPermission gets the permissions needed by the instruction, and whether the denied error could be set to the result parameter
+func (p *ByteCode) getInstrPermission(instrA *Instr) (Permission, bool) {
+	permT, toResultT := instrPermMapG[instrA.Code]
+
+	toResultT = toResultT && !instrNoResultSetG[instrA.Code]
+
+	if hostInstrT := p.getHostInstr(instrA.Code); hostInstrT != nil {
+		permT |= hostInstrT.Spec.Perm
+		toResultT = !hostInstrT.Spec.NoResult
+	}
+
+	for i, v := range instrA.Params {
+		if v.Ref == -31 { // $clip
+			permT |= PermClipboard
+
+			if i == 0 {
+				toResultT = false
 			}
+		}
+	}
 
-			OpNameMapG[idxT] = v
+	return permT, toResultT && instrA.ParamLen > 0
+}
 
-			idxT++
+// GetInstrName gets the name of the instruction by code, including the ones registered by RegisterInstr
+func GetInstrName(codeA int) string {
+	for k, v := range InstrNameSet {
+		if v == codeA {
+			return k
 		}
 	}
 
-	return fmt.Sprintf("%v", OpNameMapG[int(v)])
+	if hostInstrT := getHostInstr(codeA); hostInstrT != nil {
+		return hostInstrT.Name
+	}
+
+	return tk.ToStr(codeA)
+}
+
+// CheckPermissions checks if all the instructions are permitted, returns the error of the first one not permitted
+func (p *ByteCode) CheckPermissions(permA Permission) error {
+	for i := range p.InstrList {
+		instrT := &p.InstrList[i]
+
+		permT, _ := p.getInstrPermission(instrT)
+
+		if permT&^permA != 0 {
+			return fmt.Errorf("compile error(line %v: %v): %w", instrT.SourceLine+1, tk.LimitString(p.Source[instrT.SourceLine], 50), &PermissionError{Instr: GetInstrName(instrT.Code), Perm: permT &^ permA})
+		}
+	}
+
+	return nil
+}
+
+// CheckPermission checks if the instruction is permitted by the VM's Permissions, returns the *PermissionError if not, and whether the error could be set to the result parameter
+func (p *VM) CheckPermission(instrA *Instr) (bool, error) {
+	permT, toResultT := p.Code.getInstrPermission(instrA)
+
+	if permT&^p.Permissions == 0 {
+		return false, nil
+	}
+
+	return toResultT, &PermissionError{Instr: GetInstrName(instrA.Code), Perm: permT &^ p.Permissions}
+}
+
+// host instructions
+
+// HostInstrFunc is the handler of the instruction registered by the host application, argsA are the resolved parameters(without the result one), the returned value will be assigned to the result parameter, and the error will stop the running as a runtime error
+type HostInstrFunc func(vmA *VM, argsA []interface{}) (interface{}, error)
+
+// InstrSpec describes the parameters of the instruction registered by RegisterInstr
+type InstrSpec struct {
+	ParamLen int        // minimal parameter count, not including the result parameter
+	NoResult bool       // if true, there is no result parameter and the returned value is discarded
+	Perm     Permission // the permissions needed, such as PermNetwork
+}
+
+type hostInstr struct {
+	Name    string
+	Spec    InstrSpec
+	Handler HostInstrFunc
+}
+
+// the codes of the host instructions start from here
+const hostInstrCodeStart = 80000001
+
+var hostInstrLockG sync.RWMutex
+
+var hostInstrNameMapG = map[string]int{}
+var hostInstrMapG = map[int]*hostInstr{}
+
+// RegisterInstr registers an instruction implemented by the host application, it could be used in both Run and RunOpCodes modes and should be registered before compiling the scripts using it, returns error if the name is invalid or conflicts with an existing instruction
+func RegisterInstr(nameA string, specA InstrSpec, handlerA HostInstrFunc) error {
+	if strings.TrimSpace(nameA) != nameA || nameA == "" || strings.ContainsAny(nameA, " \t\r\n") {
+		return fmt.Errorf("invalid instr name: %#v", nameA)
+	}
+
+	if handlerA == nil {
+		return fmt.Errorf("nil handler for instr: %v", nameA)
+	}
+
+	if specA.ParamLen < 0 {
+		return fmt.Errorf("invalid parameter count for instr %v: %v", nameA, specA.ParamLen)
+	}
+
+	if _, ok := InstrNameSet[nameA]; ok {
+		return fmt.Errorf("instr name conflicts with the built-in one: %v", nameA)
+	}
+
+	hostInstrLockG.Lock()
+	defer hostInstrLockG.Unlock()
+
+	if _, ok := hostInstrNameMapG[nameA]; ok {
+		return fmt.Errorf("instr already registered: %v", nameA)
+	}
+
+	codeT := hostInstrCodeStart + len(hostInstrMapG)
+
+	hostInstrNameMapG[nameA] = codeT
+	hostInstrMapG[codeT] = &hostInstr{Name: nameA, Spec: specA, Handler: handlerA}
+
+	return nil
+}
+
+// GetInstrCode gets the code of the instruction by name, including the ones registered by RegisterInstr
+func GetInstrCode(nameA string) (int, bool) {
+	codeT, ok := InstrNameSet[nameA]
+
+	if ok {
+		return codeT, true
+	}
+
+	hostInstrLockG.RLock()
+	defer hostInstrLockG.RUnlock()
+
+	codeT, ok = hostInstrNameMapG[nameA]
+
+	return codeT, ok
+}
+
+func getHostInstr(codeA int) *hostInstr {
+	if codeA < hostInstrCodeStart {
+		return nil
+	}
+
+	hostInstrLockG.RLock()
+	defer hostInstrLockG.RUnlock()
+
+	return hostInstrMapG[codeA]
+}
+
+// getHostInstr gets the host instruction used by the code from the snapshot taken while compiling, so there is no lock while running
+func (p *ByteCode) getHostInstr(codeA int) *hostInstr {
+	if codeA < hostInstrCodeStart {
+		return nil
+	}
+
+	return p.hostInstrs[codeA]
+}
+
+// ParamLen gets the minimal parameter count of the host instruction, including the result parameter
+func (p *hostInstr) ParamLen() int {
+	if p.Spec.NoResult {
+		return p.Spec.ParamLen
+	}
+
+	return p.Spec.ParamLen + 1
 }
 
 // instructions start
@@ -135,11 +685,18 @@ var InstrNameSet map[string]int = map[string]int{
 
 	"testByText": 122, // for test purpose, check if 2 string values are equal
 
+	// error related
+
+	"isErr":     151, // check if the value is an error, usage: isErr $result $value
+	"getErrStr": 152, // get the message of an error value
+
 	// run code related
 
 	"goto": 180, // jump to the instruction line (often indicated by labels)
 
-	"exit": 199, // terminate the program, can with a return value(same as assign the global value $outG)
+	"exitCode": 198, // set the exit code of the process(an integer) without terminating, usage: exitCode #i2, the code will be used by the qx command line tool after the program ends
+
+	"exit": 199, // terminate the program, can with a return value(same as assign the global value $outG), the qx command line tool uses an integer return value as the exit code of the process
 
 	// push/peek/pop stack related
 
@@ -170,11 +727,52 @@ var InstrNameSet map[string]int = map[string]int{
 
 	"ret": 1020, // return from a normal function or a fast call function, while for normal function call, can with a paramter for set $outL
 
+	"range": 1070, // call the function for each line of the iterator returned by readLines, the function gets the index and the line as the arguments as called by call, and could return false to stop, usage: range $lines :onLine
+
 	// array/slice related
 
 	"getArrayItem": 1123,
 	"[]":           1123,
 
+	// string related
+
+	"strAdd": 1501, // concatenate 2 or more values as strings, usage: strAdd $result $str1 $str2...
+
+	"strLen": 1503, // get the length of a string in runes(characters, not bytes)
+
+	"trim": 1511, // trim the white spaces of both ends of a string, or the characters in the cutset if the 3rd parameter is given, usage: trim $result $str "cutset"
+
+	"toUpper": 1521, // convert a string to upper case
+	"toLower": 1522, // convert a string to lower case
+
+	"contains":   1531, // check if a string contains the substring, usage: contains $result $str "sub"
+	"startsWith": 1533, // check if a string starts with the prefix
+	"endsWith":   1535, // check if a string ends with the suffix
+
+	"indexOf": 1537, // get the index(in runes) of the first occurrence of the substring, -1 if not found
+
+	"replace": 1541, // replace the substring in a string, usage: replace $result $str "old" "new", replace all occurrences unless the count is given as the 5th parameter
+
+	"subStr": 1551, // get the substring by rune index, usage: subStr $result $str #i1 #i3, start from index 1 and get 3 characters, the length could be omitted to get all the rest
+
+	"repeat": 1553, // repeat a string for n times, usage: repeat $result "ab" #i3
+
+	"padLeft":  1555, // pad a string on the left to the width(in runes), usage: padLeft $result $str #i8 "0", the pad string is space if omitted
+	"padRight": 1556, // pad a string on the right to the width(in runes)
+
+	"split":      1571, // split a string to a string list, usage: split $result $str "," #i3, the count could be omitted
+	"splitLines": 1573, // split a string to lines
+	"join":       1575, // join a list to a string by the separator, usage: join $result $list ","
+
+	// regex related, the patterns are in Golang(RE2) syntax
+
+	"regMatch":      1611, // check if the string contains a match of the pattern(use ^ and $ to match the whole string), usage: regMatch $result $str `^\d+$`
+	"regFind":       1613, // find the first match of the pattern, usage: regFind $result $str $pattern #i1, the group index could be omitted(0 - the whole match)
+	"regFindAll":    1615, // find all matches of the pattern, return a string list, usage: regFindAll $result $str $pattern #i1, the group index could be omitted
+	"regFindGroups": 1617, // find the first match and return the whole match and all the groups as a string list
+	"regReplace":    1621, // replace all matches of the pattern, $1/${name} in the replacement are expanded, usage: regReplace $result $str $pattern $replacement
+	"regSplit":      1631, // split the string by the pattern, usage: regSplit $result $str $pattern #i3, the count could be omitted
+
 	// time related
 	"now": 1910, // get the current time
 
@@ -183,22 +781,102 @@ var InstrNameSet map[string]int = map[string]int{
 	"pln": 10410, // same as println function in other languages
 	"plo": 10411, // print a value with its type
 
-	"pl": 10420,
+	"pr":    10401, // print the formatted string without the line ending, usage: pr "name: %v" $name
+	"pl":    10420, // print the formatted string with a line ending, usage: pl "name: %v, age: %v" $name $age, or with the placeholder mode: pl "name: {}, age: {}" $name $age
+	"plErr": 10430, // same as pl but print to stderr
+
+	"spr": 10451, // format a string to the variable, the same format with pl, usage: spr $result "%v-%03d" $s1 $n1
+
+	// input related, read from the stdin of the VM(os.Stdin by default)
+
+	"readLine":  10501, // read a line(without the line ending), the result is an error value(EOF) if no more input
+	"readAll":   10503, // read all the remaining input as a string
+	"readLines": 10505, // get an iterator of the remaining input lines for range, the lines are read lazily while iterating, so the input could be streamed
+	"getInput":  10511, // print the prompt and read a line, usage: getInput $result "your name: "
+
+	// command-line related, the command-line arguments are in the global variable $argsG
+
+	"getParam":       20301, // get the n-th command-line parameter which is not a switch(not started with -), usage: getParam $result #i1 "default value", the default value could be omitted
+	"getSwitch":      20303, // get the value of the command-line switch, usage: getSwitch $result "-port=" "8080", the default value could be omitted
+	"ifSwitchExists": 20305, // check if the command-line switch exists, usage: ifSwitchExists $result "-verbose"
+	"params":         20311, // declare the typed command-line options and get a map of their values, usage: params $result "-port:int=8080 the port to listen" "-host=localhost the host name" "-verbose:bool verbose output", the type could be string(default), int, float or bool, the other arguments(including the ones like -5 and all after "--") are in the key "args", and the qx command line tool generates the --help output from the declaration
 
 	// system related
 
 	"sleep": 20501, // sleep for n seconds(float, 0.001 means 1 millisecond)
 
+	"trap": 20505, // jump to the label at the next instruction when the signal is received, usage: trap "SIGINT" :onInterrupt, the signal could be SIGINT, SIGTERM, SIGHUP or SIGQUIT, the handler should end with exit and the deferred instructions will be run before exiting
+
 	"getClipText": 20511, // get clipboard content as text
 
 	"setClipText": 20512, // set clipboard content as text
 
-	"getEnv":    20521, // get os environment variable by key
-	"setEnv":    20522, // set os environment variable by key and value
-	"removeEnv": 20523, // remove os environment variable by key
+	"getEnv":     20521, // get os environment variable by key, usage: getEnv $result "HOME" "default value", the default value could be omitted
+	"setEnv":     20522, // set os environment variable by key and value, usage: setEnv $result "KEY" "value", the result is an error value if failed
+	"removeEnv":  20523, // remove os environment variable by key
+	"getEnvList": 20525, // get all the os environment variables as a string list in the form "key=value"
+	"expandEnv":  20527, // replace ${var} or $var in the string according to the os environment variables
 
 	"systemCmd": 20601, // run an os shell command, usage： systemCmd "cmd" "/k" "copy a.txt b.txt"
 
+	"systemCmdEx": 20603, // run an os command with options, return a map with the keys: stdout, stderr, exitCode, duration(seconds), timeout(bool), usage: systemCmdEx $result -cwd=/tmp -env=K=V $"-stdin=${$s}" -timeout=5 "git" "status", the options should be before the command and could be omitted, the option values are literal(use the interpolated string like $"-cwd=${$dir}" for a variable), -env could be used several times, the exit code is -1 if the command is timeout
+
+	// process related, for the processes running in background
+
+	"startProcess":      20611, // start a process in background and return the handle, usage: startProcess $handle $"-cwd=${$dir}" -env=K=V "server" "-port=8080", the options should be before the command and could be omitted
+	"waitProcess":       20613, // wait for the process to exit, return a map with the keys: exitCode, duration(seconds), dropped(the count of the output lines dropped since they are not read, only the latest 10000 lines of stdout and stderr are kept), usage: waitProcess $result $handle -timeout=5, the result is an error value if timeout
+	"killProcess":       20615, // kill the process, usage: killProcess $result $handle
+	"readProcessOutput": 20617, // read a line from the stdout(or stderr with -stderr) of the process, the result is an error value(EOF) if no more output, usage: readProcessOutput $line $handle -stderr -timeout=1
+	"writeProcessInput": 20619, // write the string to the stdin of the process, usage: writeProcessInput $result $handle $str -close, close the stdin after writing if -close is given
+
+	"pipe": 20631, // run the stages in a pipeline, the stdout of each stage is streamed to the stdin of the next one, return a map with the keys: output, exitCodes, stderr(list for each stage), usage: pipe $result "ls -l" #L`["grep", "qx"]` :filterFunc $"-stdin=${$s}" -cwd=/tmp -env=K=V, a stage could be a command line string, a list of the command and arguments, or a label of the function which receives an iterator of the input lines(read while the previous stages are running, use range or join to consume it) as the argument and returns the output
+
+	// goroutine related
+
+	"go":   20641, // run the function at the label in a goroutine with a child VM(sharing the global variables but with its own stacks and local variables), return the task handle, usage: go $task :fetch $url, the function gets the arguments and returns the value as called by call, the tasks still running are cancelled and waited when the script ends
+	"wait": 20643, // wait for the task started by go to finish and get the return value of the function, the result is an error value if the function failed, usage: wait $result $task
+
+	// file related, these instructions set an error value to the result instead of raising a runtime error if failed, use isErr to check
+
+	"loadText":   21101, // load the content of a file as a string, usage: loadText $result "a.txt"
+	"saveText":   21103, // save a string to a file, usage: saveText $result $str "a.txt", the result is an empty string if succeeded
+	"appendText": 21105, // append a string to a file, create it if not exists
+	"loadBytes":  21111, // load the content of a file as bytes
+	"saveBytes":  21113, // save bytes to a file, usage: saveBytes $result $bytes "a.bin"
+
+	"fileExists": 21201, // check if the file or directory exists
+	"isDir":      21203, // check if the path is an existing directory
+	"fileInfo":   21205, // get the information of a file as a map, with the keys: name, size, isDir, mode, modTime
+	"listDir":    21211, // get the names of the files in the directory, usage: listDir $result "." "*.qx", the glob pattern could be omitted
+
+	"removeFile":     21301, // remove a file or an empty directory
+	"renameFile":     21303, // rename/move a file, usage: renameFile $result "a.txt" "b.txt"
+	"copyFile":       21305, // copy a file, usage: copyFile $result "a.txt" "b.txt"
+	"ensureMakeDirs": 21311, // create the directory and all the parent directories if not exist
+
+	// path related
+
+	"joinPath":    21901, // join the path elements, usage: joinPath $result $scriptDirG "sub" "a.qx"
+	"getFileBase": 21903, // get the last element of the path, i.e. the file name
+	"getFileExt":  21905, // get the extension of the file name, with the dot, such as ".qx"
+	"getFileDir":  21907, // get the directory of the path
+
+	"absPath": 21911, // get the absolute path
+	"relPath": 21913, // get the relative path to the base path, usage: relPath $result $basePath $targetPath
+
+	"getCurrentDir": 21921, // get the current working directory
+	"setCurrentDir": 21922, // change the current working directory, the result is an error value if failed
+	"getHomeDir":    21925, // get the home directory of the current user
+	"getTempDir":    21927, // get the default directory for temporary files
+
+	// Go binding related, the Go functions and values are bound by VM.Bind in the host application
+
+	"callGo": 22101, // call the bound Go function, the arguments are converted to the parameter types, multiple return values are returned as a list, and a non-nil trailing error is returned as an error value, usage: callGo $result "strings.ToUpper" $s, get the value itself if it is not a function and no argument is given
+
+	"method":   22111, // call the method of the Go value(such as time.Time or a pointer to struct) by reflection, the same conversion as callGo, usage: method $result $time "Format" "2006-01-02"
+	"getField": 22113, // get the exported field of the struct(or pointer to struct) value, usage: getField $result $obj "Name"
+	"setField": 22115, // set the exported field of the struct value, the result is the same pointer for a pointer to struct or the modified copy for a struct, usage: setField $result $obj "Name" $value
+
 	// operator related extra
 
 	"++i": 9999900011,
@@ -211,7 +889,7 @@ var InstrNameSet map[string]int = map[string]int{
 }
 
 type VarRef struct {
-	Ref   int // -99 - invalid, -56 - integer label, -31 - clipboard(text), -23 - slice of array/slice, -22 - map item, -21 - array/slice item, -18 - local reg, -17 - reg, -16 - label, -15 - ref, -12 - unref, -11 - seq, -10 - quickEval, -9 - flexEval, -8 - pop, -7 - peek, -6 - push, -5 - tmp, -4 - pln, -3 - value only, -2 - drop, -1 - debug, 3 normal vars
+	Ref   int // -99 - invalid, -61 - interpolated string, -56 - integer label, -31 - clipboard(text), -23 - slice of array/slice, -22 - map item, -21 - array/slice item, -18 - local reg, -17 - reg, -16 - label, -15 - ref, -12 - unref, -11 - seq, -10 - quickEval, -9 - flexEval, -8 - pop, -7 - peek, -6 - push, -5 - tmp, -4 - pln, -3 - value only, -2 - drop, -1 - debug, 3 normal vars, 4 global vars(by name, such as $argsG)
 	Value interface{}
 }
 
@@ -250,6 +928,33 @@ type ByteCode struct {
 	// for trace
 	InstrToLineMap map[int]int
 	// OpCodeListToLineMap map[int]int
+
+	// instruction index -> opcode index, for the labels in DeepCompile mode
+	DeepLabelMap map[int]int
+
+	undealtLabels []int
+
+	// the first error while lowering the parameters in DeepCompile
+	dealErr error
+
+	// DeepCompile does nothing if the code has been deep compiled, so the code could be shared by the VMs safely
+	deepCompiled bool
+	deepLock     sync.Mutex
+
+	// the error of DeepCompile, the code is run by instructions if not nil
+	deepErr error
+
+	// print the debug information while compiling, set by the -debug option of Compile
+	Debug bool
+
+	// the writer of the debug information, set by an io.Writer option of Compile, os.Stderr by default
+	debugWriter io.Writer
+
+	// the host instructions(registered by RegisterInstr) used by the code, taken while compiling
+	hostInstrs map[int]*hostInstr
+
+	// the constant regex patterns compiled while compiling the code, not changed after Compile
+	regexCache map[string]*regexp.Regexp
 }
 
 type VM struct {
@@ -273,6 +978,69 @@ type VM struct {
 	PointerStack *tk.SimpleStack
 
 	ErrorHandler int
+
+	// the context to interrupt the running, such as sleep
+	Ctx context.Context
+
+	// running by RunOpCodes(DeepCompile mode) or by Run
+	UseOpCodes bool
+
+	// if the code is running, for CallLabel to determine the engine
+	running bool
+
+	// the context of the tasks started by go in the current run, cancelled when the run ends
+	tasksCtx context.Context
+
+	// the maximum count of the instructions(or opcodes) to run, 0 means no limit
+	MaxInstrs int
+
+	// the permissions of the running script, PermAll by default
+	Permissions Permission
+
+	// the resource limits, no limit by default
+	Limits Limits
+
+	// print the debug information while running
+	Debug bool
+
+	// shared with the child VMs started by go
+	globalsLock *sync.RWMutex
+	outputLock  *sync.Mutex
+	budget      *vmBudget
+	stdin       *sharedStdin
+	regexLRU    *regexLRU
+
+	// the count of the instructions run by this VM, to check the context periodically
+	instrCount int
+
+	// the output of the print instructions, buffered and flushed when the running ends or before blocking operations
+	Stdout io.Writer
+	Stderr io.Writer
+
+	stdoutWriter *bufio.Writer
+	stdoutTarget io.Writer
+	stderrWriter *bufio.Writer
+	stderrTarget io.Writer
+
+	// the input for readLine, readAll, readLines and getInput
+	Stdin io.Reader
+
+	// signal name -> code pointer of the handler, set by trap
+	Traps map[string]int
+
+	signalC chan os.Signal
+
+	// the count of the running CallFunc, the traps are only handled at the top level
+	nestedCalls int
+
+	// the options passed to NewVM, applied again by Reset
+	options []VMOption
+
+	// the count of the leading items in $argsG which are not the arguments of the script(such as the executable and the script path), skipped by params
+	ArgsOffset int
+
+	// the Go functions and values bound by Bind, for callGo
+	Bindings map[string]interface{}
 }
 
 type CallStruct struct {
@@ -314,17 +1082,120 @@ func NewFuncContext() *FuncContext {
 	return rs
 }
 
-func NewVM(codeA *ByteCode, inputA ...interface{}) *VM {
-	var inputT interface{} = nil
+// VMOption is the functional option for NewVM
+type VMOption func(*VM)
+
+// WithContext sets the context to interrupt the running
+func WithContext(ctxA context.Context) VMOption {
+	return func(p *VM) {
+		p.Ctx = ctxA
+	}
+}
+
+// WithStdin sets the input for readLine, readAll, readLines and getInput
+func WithStdin(readerA io.Reader) VMOption {
+	return func(p *VM) {
+		p.Stdin = readerA
+	}
+}
+
+// WithStdout sets the output of the print instructions
+func WithStdout(writerA io.Writer) VMOption {
+	return func(p *VM) {
+		p.Stdout = writerA
+	}
+}
+
+// WithStderr sets the output of plErr
+func WithStderr(writerA io.Writer) VMOption {
+	return func(p *VM) {
+		p.Stderr = writerA
+	}
+}
+
+// WithArgs sets the command-line arguments(argsG) of the script instead of os.Args, all of them are the arguments of the script(ArgsOffset is 0)
+func WithArgs(argsA ...string) VMOption {
+	return func(p *VM) {
+		p.SetGlobal("argsG", argsA)
+		p.ArgsOffset = 0
+	}
+}
+
+// WithBinding binds the Go function or value to the name, the same as VM.Bind
+func WithBinding(nameA string, valueA interface{}) VMOption {
+	return func(p *VM) {
+		p.Bind(nameA, valueA)
+	}
+}
+
+// WithMaxInstrs sets the maximum count of the instructions to run, 0 means no limit
+func WithMaxInstrs(countA int) VMOption {
+	return func(p *VM) {
+		p.MaxInstrs = countA
+	}
+}
+
+// WithPermissions sets the permissions of the running script, such as PermFsRead|PermEnv
+func WithPermissions(permA Permission) VMOption {
+	return func(p *VM) {
+		p.Permissions = permA
+	}
+}
+
+// WithLimits sets the resource limits of the VM
+func WithLimits(limitsA Limits) VMOption {
+	return func(p *VM) {
+		p.Limits = limitsA
+	}
+}
+
+// WithDebug sets if print the debug information while running
+func WithDebug(debugA bool) VMOption {
+	return func(p *VM) {
+		p.Debug = debugA
+	}
+}
 
-	if len(inputA) > 0 {
-		inputT = inputA[0]
+// WithGlobal sets a global variable before running
+func WithGlobal(nameA string, valueA interface{}) VMOption {
+	return func(p *VM) {
+		p.SetGlobal(nameA, valueA)
 	}
+}
 
+// NewVM creates a VM to run the compiled code with the options, the input value is passed by Run
+func NewVM(codeA *ByteCode, optsA ...VMOption) *VM {
 	p := &VM{}
 
+	p.options = optsA
+
 	p.Code = codeA
 
+	p.Ctx = context.Background()
+
+	p.Stdin = os.Stdin
+	p.Stdout = os.Stdout
+	p.Stderr = os.Stderr
+
+	p.Permissions = PermAll
+
+	// os.Args of the host application, the first one is the executable
+	p.ArgsOffset = 1
+
+	p.outputLock = &sync.Mutex{}
+	p.stdin = &sharedStdin{}
+	p.regexLRU = newRegexLRU()
+
+	p.initState()
+
+	for _, v := range p.options {
+		v(p)
+	}
+
+	return p
+}
+
+func (p *VM) initState() {
 	p.Seq = tk.NewSeq()
 
 	p.Regs = make([]interface{}, 10)
@@ -343,10 +1214,32 @@ func NewVM(codeA *ByteCode, inputA ...interface{}) *VM {
 
 	p.ErrorHandler = -1
 
+	p.StopTraps()
+
+	p.budget = &vmBudget{}
+
 	p.Regs[0] = map[string]interface{}{"undefined": tk.Undefined, "argsG": os.Args}
-	p.Regs[1] = inputT
+	p.globalsLock = &sync.RWMutex{}
+}
 
-	return p
+// ErrTasksRunning is returned by Reset while the tasks started by go are still running
+var ErrTasksRunning = errors.New("tasks still running")
+
+// Reset clears the running state(variables, stacks, global variables and so on) so the VM could be reused to run the code again, the options passed to NewVM are applied again, returns ErrTasksRunning(and does nothing) if the tasks started by go(in this VM or its child VMs) are still running
+func (p *VM) Reset() error {
+	if p.budget.tasks.Load() > 0 {
+		return ErrTasksRunning
+	}
+
+	p.Flush()
+
+	p.initState()
+
+	for _, v := range p.options {
+		v(p)
+	}
+
+	return nil
 }
 
 func ParseLine(commandA string) ([]string, error) {
@@ -447,9 +1340,26 @@ func ParseLine(commandA string) ([]string, error) {
 	return args, nil
 }
 
-func (p *ByteCode) ParseVar(strA string, optsA ...interface{}) VarRef {
-	// tk.Pl("parseVar: %#v", strA)
-	s1T := strings.TrimSpace(strA)
+// isGlobalName checks if the name(without the leading $) is a global variable, which is an identifier ending with G, such as scriptDirG, other names like $foo are still the literal strings
+func isGlobalName(strA string) bool {
+	if len(strA) < 2 || !strings.HasSuffix(strA, "G") {
+		return false
+	}
+
+	for i, c := range strA {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+
+		return false
+	}
+
+	return true
+}
+
+func (p *ByteCode) ParseVar(strA string, optsA ...interface{}) VarRef {
+	// tk.Pl("parseVar: %#v", strA)
+	s1T := strings.TrimSpace(strA)
 
 	if strings.HasPrefix(s1T, "`") && strings.HasSuffix(s1T, "`") {
 		s1T = s1T[1 : len(s1T)-1]
@@ -464,7 +1374,9 @@ func (p *ByteCode) ParseVar(strA string, optsA ...interface{}) VarRef {
 
 		return VarRef{-3, tmps} // value(string)
 	} else {
-		if strings.HasPrefix(s1T, "$") {
+		if strings.HasPrefix(s1T, `$"`) && strings.HasSuffix(s1T, `"`) && len(s1T) > 2 { // interpolated string
+			return p.ParseTemplate(s1T[1:])
+		} else if strings.HasPrefix(s1T, "$") {
 			numT, errT := tk.StrToIntQuick(s1T[1:])
 
 			if errT == nil {
@@ -491,6 +1403,10 @@ func (p *ByteCode) ParseVar(strA string, optsA ...interface{}) VarRef {
 				return VarRef{-31, nil}
 			}
 
+			if isGlobalName(s1T[1:]) {
+				return VarRef{4, s1T[1:]}
+			}
+
 			// } else if strings.HasPrefix(s1T, "&") { // ref
 			// 	vNameT := s1T[1:]
 
@@ -1031,13 +1947,86 @@ func (p *ByteCode) ParseVar(strA string, optsA ...interface{}) VarRef {
 	return VarRef{-3, s1T}
 }
 
-func Compile(scriptA string) (*ByteCode, error) {
-	if DebugG {
-		tk.Pl("compiling: %#v", scriptA)
+// ParseTemplate parses the quoted string(with the quotes) of an interpolated string literal like $"count=${$1} name=${[$2,#i0]}", each ${...} could be any variable expression
+func (p *ByteCode) ParseTemplate(quotedA string) VarRef {
+	strT, errT := strconv.Unquote(quotedA)
+
+	if errT != nil {
+		strT = quotedA[1 : len(quotedA)-1]
+	}
+
+	partsT := make([]VarRef, 0)
+
+	var sb strings.Builder
+
+	runesT := []rune(strT)
+
+	for i := 0; i < len(runesT); i++ {
+		if runesT[i] == '$' && i+1 < len(runesT) && runesT[i+1] == '{' {
+			endT := -1
+			depthT := 0
+
+			for j := i + 1; j < len(runesT); j++ {
+				if runesT[j] == '{' {
+					depthT++
+				} else if runesT[j] == '}' {
+					depthT--
+
+					if depthT == 0 {
+						endT = j
+						break
+					}
+				}
+			}
+
+			if endT >= 0 {
+				if sb.Len() > 0 {
+					partsT = append(partsT, VarRef{-3, sb.String()})
+					sb.Reset()
+				}
+
+				partsT = append(partsT, p.ParseVar(string(runesT[i+2:endT])))
+
+				i = endT
+				continue
+			}
+		}
+
+		sb.WriteRune(runesT[i])
+	}
+
+	if sb.Len() > 0 {
+		partsT = append(partsT, VarRef{-3, sb.String()})
 	}
 
+	return VarRef{-61, partsT}
+}
+
+// Compile compiles the script to instructions and opcodes(by DeepCompile, if any instruction could not be lowered, the VM will run the instructions and the reason is got by DeepCompileErr), optsA could be a Permission value(the instructions not permitted are rejected), "-debug"(print the debug information), an io.Writer(where the debug information is written, os.Stderr by default) or "-noDeep"(only compile to instructions, the VM will run the instructions instead of the opcodes)
+func Compile(scriptA string, optsA ...interface{}) (*ByteCode, error) {
 	p := &ByteCode{}
 
+	permT := PermAll
+
+	noDeepT := false
+
+	for _, v := range optsA {
+		switch nv := v.(type) {
+		case Permission:
+			permT = nv
+		case io.Writer:
+			p.debugWriter = nv
+		case string:
+			if nv == "-debug" {
+				p.Debug = true
+			} else if nv == "-noDeep" {
+				noDeepT = true
+			}
+		}
+	}
+
+	p.plDebug("compiling: %#v", scriptA)
+
 	p.Consts = make([]interface{}, 0)
 	p.Labels = make(map[string]int)
 	p.InstrList = make([]Instr, 0)
@@ -1045,6 +2034,8 @@ func Compile(scriptA string) (*ByteCode, error) {
 
 	p.InstrToLineMap = make(map[int]int)
 
+	p.regexCache = make(map[string]*regexp.Regexp)
+
 	originCodeLenT := 0
 
 	sourceT := tk.SplitLines(scriptA)
@@ -1107,9 +2098,7 @@ func Compile(scriptA string) (*ByteCode, error) {
 		pointerT++
 	}
 
-	if DebugG {
-		tk.Pl("codeLisT: %#v", codeListT)
-	}
+	p.plDebug("codeLisT: %#v", codeListT)
 
 	for i := originCodeLenT; i < len(codeListT); i++ {
 		// listT := strings.SplitN(v, " ", 3)
@@ -1120,15 +2109,21 @@ func Compile(scriptA string) (*ByteCode, error) {
 			return nil, fmt.Errorf("failed to parse parmaters: %v", errT)
 		}
 
-		if DebugG {
-			tk.Plv(listT)
-		}
+		p.plDebug("%#v", listT)
 
 		lenT := len(listT)
 
 		instrNameT := strings.TrimSpace(listT[0])
 
-		codeT, ok := InstrNameSet[instrNameT]
+		codeT, ok := GetInstrCode(instrNameT)
+
+		if hostInstrT := getHostInstr(codeT); hostInstrT != nil {
+			if p.hostInstrs == nil {
+				p.hostInstrs = make(map[int]*hostInstr)
+			}
+
+			p.hostInstrs[codeT] = hostInstrT
+		}
 
 		if !ok {
 			instrT := Instr{SourceLine: p.InstrToLineMap[i], Code: codeT, ParamLen: 1, Params: []VarRef{VarRef{Ref: -3, Value: v}}}
@@ -1146,12 +2141,24 @@ func Compile(scriptA string) (*ByteCode, error) {
 				continue
 			}
 
+			// the labels of pipe are the script function stages, kept as stageLabel so they are not mixed up with int values
+			if codeT == 20631 && strings.HasPrefix(jv, ":") && len(jv) > 1 {
+				list3T = append(list3T, VarRef{-3, stageLabel(jv)})
+				continue
+			}
+
 			list3T = append(list3T, p.ParseVar(jv, i))
 		}
 
 		instrT.Params = append(instrT.Params, list3T...)
 		instrT.ParamLen = lenT - 1
 
+		errT = p.PrepareRegexps(&instrT)
+
+		if errT != nil {
+			return nil, fmt.Errorf("compile error(line %v %v): %v", i, tk.LimitString(v, 50), errT)
+		}
+
 		p.InstrList = append(p.InstrList, instrT)
 	}
 
@@ -1159,11 +2166,63 @@ func Compile(scriptA string) (*ByteCode, error) {
 	// tk.Plv(p.CodeListM)
 	// tk.Plv(p.CodeSourceMapM)
 
+	if permT != PermAll {
+		errT := p.CheckPermissions(permT)
+
+		if errT != nil {
+			return nil, errT
+		}
+	}
+
+	if !noDeepT {
+		errT := p.DeepCompile()
+
+		if errT != nil {
+			p.plDebug("deep compile failed, will run by instructions: %v", errT)
+		}
+	}
+
 	return p, nil
 }
 
+// GetGlobal gets the value of the global variable by name, returns tk.Undefined if not exists
+func (p *VM) GetGlobal(nameA string) interface{} {
+	p.globalsLock.RLock()
+	defer p.globalsLock.RUnlock()
+
+	mapT, ok := p.Regs[0].(map[string]interface{})
+
+	if !ok {
+		return tk.Undefined
+	}
+
+	v, ok := mapT[nameA]
+
+	if !ok {
+		return tk.Undefined
+	}
+
+	return v
+}
+
+// SetGlobal sets the value of the global variable by name, such as "scriptDirG"
+func (p *VM) SetGlobal(nameA string, valueA interface{}) {
+	p.globalsLock.Lock()
+	defer p.globalsLock.Unlock()
+
+	mapT, ok := p.Regs[0].(map[string]interface{})
+
+	if !ok {
+		mapT = make(map[string]interface{})
+
+		p.Regs[0] = mapT
+	}
+
+	mapT[nameA] = valueA
+}
+
 func (p *VM) GetCurrentFuncContext() *FuncContext {
-	plDebug("GetCurrentFuncContext: %#v", p.FuncStack)
+	p.plDebug("GetCurrentFuncContext: %#v", p.FuncStack)
 	if p.FuncStack.Size() < 1 {
 		return nil
 	}
@@ -1174,6 +2233,10 @@ func (p *VM) GetCurrentFuncContext() *FuncContext {
 func (p *VM) SetVar(refA VarRef, setValueA interface{}) error {
 	// tk.Pln(refA, "->", setValueA)
 
+	if errT := p.checkValue(setValueA); errT != nil {
+		return errT
+	}
+
 	refIntT := refA.Ref
 
 	if refIntT == -2 { // $drop
@@ -1181,7 +2244,7 @@ func (p *VM) SetVar(refA VarRef, setValueA interface{}) error {
 	}
 
 	if refIntT == -4 { // $pln
-		fmt.Println(setValueA)
+		p.Pln(setValueA)
 		return nil
 	}
 
@@ -1237,6 +2300,11 @@ func (p *VM) SetVar(refA VarRef, setValueA interface{}) error {
 	// 	return nil
 	// }
 
+	if refIntT == 4 { // global vars
+		p.SetGlobal(refA.Value.(string), setValueA)
+		return nil
+	}
+
 	if refIntT != 3 {
 		return fmt.Errorf("unsupported var reference")
 	}
@@ -1355,6 +2423,20 @@ func (p *VM) GetVarValue(vA VarRef) interface{} {
 		return vA.Value
 	}
 
+	if idxT == -61 { // interpolated string
+		var sb strings.Builder
+
+		for _, v := range vA.Value.([]VarRef) {
+			sb.WriteString(tk.ToStr(p.GetVarValue(v)))
+		}
+
+		return sb.String()
+	}
+
+	if idxT == 4 { // global variables
+		return p.GetGlobal(vA.Value.(string))
+	}
+
 	if idxT == 3 { // normal variables
 		lenT := p.FuncStack.Size()
 
@@ -1409,6 +2491,17 @@ func (p *VM) ParamsToList(v *Instr, fromA int) []interface{} {
 	return sl
 }
 
+// PopArgs pops lenA values from the internal stack, in the order of the instruction parameters
+func (p *VM) PopArgs(lenA int) []interface{} {
+	sl := make([]interface{}, lenA)
+
+	for i := 0; i < lenA; i++ {
+		sl[i] = p.InternalStack.Pop()
+	}
+
+	return sl
+}
+
 func (p *VM) GetLabelIndex(inputA interface{}) int {
 	// tk.Pl("GetLabelIndex: %#v", inputA)
 	c, ok := inputA.(int)
@@ -1440,191 +2533,3217 @@ func (p *VM) GetLabelIndex(inputA interface{}) int {
 	return -1
 }
 
-func RunInstr(p *VM, instrA *Instr) (resultR interface{}) {
-	// startT := time.Now()
+// string related, shared by RunInstr and RunOpCodes
 
-	defer func() {
-		// endT := time.Now()
+func argsToStr(argsA []interface{}) string {
+	var sb strings.Builder
 
-		// tk.Pl("instr(%#v) dur: %d", instrA.Code, endT.Sub(startT))
+	for _, v := range argsA {
+		sb.WriteString(tk.ToStr(v))
+	}
 
-		if r1 := recover(); r1 != nil {
-			// tk.Printfln("exception: %v", r)
-			// if p.ErrorHandler > -1 {
-			// 	p.SetVarGlobal("lastLineG", r.CodeSourceMap[r.CodePointer]+1)
-			// 	p.SetVarGlobal("errorMessageG", tk.ToStr(r))
-			// 	p.SetVarGlobal("errorDetailG", fmt.Errorf("runtime error: %v\n%v", r, string(debug.Stack())))
+	return sb.String()
+}
 
-			// 	// p.Stack.Push(fmt.Errorf("runtime error: %v\n%v", r, string(debug.Stack())))
-			// 	// p.Stack.Push(tk.ToStr(r))
-			// 	// p.Stack.Push(r.CodeSourceMap[r.CodePointer] + 1)
-			// 	resultR = r.ErrorHandler
-			// 	return
-			// }
+// isErrValue checks if the value is an error value(for isErr), the strings are never errors even if they look like "TXERROR:..."
+func isErrValue(vA interface{}) bool {
+	_, ok := vA.(error)
 
-			resultR = fmt.Errorf("runtime exception: %v\n%v", r1, string(debug.Stack()))
+	return ok
+}
 
-			return
-		}
-	}()
+func argAt(argsA []interface{}, idxA int, defaultA interface{}) interface{} {
+	if idxA < len(argsA) {
+		return argsA[idxA]
+	}
 
-	var instrT *Instr = instrA
+	return defaultA
+}
 
-	// if p.VerbosePlusM {
-	// tk.Plv(instrT)
-	// }
+func toStrList(vA interface{}) []string {
+	switch nv := vA.(type) {
+	case []string:
+		return nv
+	case []interface{}:
+		sl := make([]string, 0, len(nv))
 
-	if instrT == nil {
-		return fmt.Errorf("nil instr: %v", instrT)
+		for _, v := range nv {
+			sl = append(sl, tk.ToStr(v))
+		}
+
+		return sl
 	}
 
-	cmdT := instrT.Code
+	valueT := reflect.ValueOf(vA)
 
-	switch cmdT {
-	case 12: // invalidInstr
-		return fmt.Errorf("invalid instr: %v", instrT.Params[0].Value)
-	case 100: // version
-		pr := instrT.Params[0]
+	if valueT.Kind() == reflect.Array || valueT.Kind() == reflect.Slice {
+		lenT := valueT.Len()
 
-		p.SetVar(pr, VersionG)
+		sl := make([]string, 0, lenT)
 
-		return ""
-	case 101: // pass
-		return ""
-	case 122: // testByText
-		if instrT.ParamLen < 2 {
-			return p.Errf("not enough parameters(参数不够)")
+		for i := 0; i < lenT; i++ {
+			sl = append(sl, tk.ToStr(valueT.Index(i).Interface()))
 		}
 
-		v1 := tk.ToStr(p.GetVarValue(instrT.Params[0]))
-		v2 := tk.ToStr(p.GetVarValue(instrT.Params[1]))
+		return sl
+	}
 
-		// tk.Plo("--->", v2)
+	return []string{tk.ToStr(vA)}
+}
 
-		var v3 string
-		var v4 string
+func strIndexOf(strA string, subA string) int {
+	idxT := strings.Index(strA, subA)
 
-		if instrT.ParamLen > 3 {
-			v3 = tk.ToStr(p.GetVarValue(instrT.Params[2]))
-			v4 = "(" + tk.ToStr(p.GetVarValue(instrT.Params[3])) + ")"
-		} else if instrT.ParamLen > 2 {
-			v3 = tk.ToStr(p.GetVarValue(instrT.Params[2]))
-		} else {
-			v3 = tk.ToStr(tk.AutoSeq.Get())
-		}
+	if idxT < 0 {
+		return -1
+	}
 
-		if v1 == v2 {
-			tk.Pl("test %v%v passed", v3, v4)
-		} else {
-			return p.Errf("test %v%v failed: (pos: %v) %#v <-> %#v\n-----\n%v\n-----\n%v", v3, v4, tk.FindFirstDiffIndex(v1, v2), v1, v2, v1, v2)
-		}
+	return len([]rune(strA[:idxT]))
+}
 
-		return ""
+// lenA < 0 means to the end of the string
+func strSubStr(strA string, startA int, lenA int) string {
+	rs := []rune(strA)
 
-	case 180: // goto
-		if instrT.ParamLen < 1 {
-			return p.Errf("not enough parameters")
-		}
+	if startA < 0 {
+		startA = 0
+	}
 
-		v1 := p.GetVarValue(instrT.Params[0]).(int)
+	if startA >= len(rs) {
+		return ""
+	}
 
-		// c1 := p.GetLabelIndex(v1)
+	endT := len(rs)
 
-		if v1 >= 0 {
-			return v1
-		}
+	if lenA >= 0 && startA+lenA < endT {
+		endT = startA + lenA
+	}
 
-		return p.Errf("invalid label: %v", v1)
+	return string(rs[startA:endT])
+}
 
-	case 199: // exit
-		if instrT.ParamLen < 1 {
-			return "exit"
-		}
+func strPad(strA string, widthA int, padA string, leftA bool) string {
+	if padA == "" {
+		padA = " "
+	}
 
-		valueT := p.GetVarValue(instrT.Params[0])
+	lenT := len([]rune(strA))
 
-		p.Regs[2] = valueT
+	if lenT >= widthA {
+		return strA
+	}
 
-		return "exit"
+	padRunesT := []rune(padA)
 
-	case 220: // push
-		if instrT.ParamLen < 1 {
-			return p.Errf("not enough parameters")
-		}
+	fillT := make([]rune, widthA-lenT)
 
-		v1 := p.GetVarValue(instrT.Params[0])
+	for i := range fillT {
+		fillT[i] = padRunesT[i%len(padRunesT)]
+	}
 
-		p.Stack.Push(v1)
+	if leftA {
+		return string(fillT) + strA
+	}
 
-		return ""
-	case 222: // peek
-		if instrT.ParamLen < 1 {
-			return p.Errf("not enough parameters")
+	return strA + string(fillT)
+}
+
+// EvalStrInstr runs the string related instructions with the resolved parameters(without the result one)
+func EvalStrInstr(codeA int, argsA []interface{}) interface{} {
+	s1 := tk.ToStr(argAt(argsA, 0, ""))
+
+	switch codeA {
+	case 1501: // strAdd
+		return argsToStr(argsA)
+	case 1503: // strLen
+		return len([]rune(s1))
+	case 1511: // trim
+		if len(argsA) > 1 {
+			return strings.Trim(s1, tk.ToStr(argsA[1]))
 		}
 
-		pr := instrT.Params[0]
+		return strings.TrimSpace(s1)
+	case 1521: // toUpper
+		return strings.ToUpper(s1)
+	case 1522: // toLower
+		return strings.ToLower(s1)
+	case 1531: // contains
+		return strings.Contains(s1, tk.ToStr(argAt(argsA, 1, "")))
+	case 1533: // startsWith
+		return strings.HasPrefix(s1, tk.ToStr(argAt(argsA, 1, "")))
+	case 1535: // endsWith
+		return strings.HasSuffix(s1, tk.ToStr(argAt(argsA, 1, "")))
+	case 1537: // indexOf
+		return strIndexOf(s1, tk.ToStr(argAt(argsA, 1, "")))
+	case 1541: // replace
+		return strings.Replace(s1, tk.ToStr(argAt(argsA, 1, "")), tk.ToStr(argAt(argsA, 2, "")), tk.ToInt(argAt(argsA, 3, -1), -1))
+	case 1551: // subStr
+		return strSubStr(s1, tk.ToInt(argAt(argsA, 1, 0), 0), tk.ToInt(argAt(argsA, 2, -1), -1))
+	case 1553: // repeat
+		countT := tk.ToInt(argAt(argsA, 1, 0), 0)
+
+		if countT < 0 {
+			countT = 0
+		}
 
-		errT := p.SetVar(pr, p.Stack.Peek())
+		return strings.Repeat(s1, countT)
+	case 1555: // padLeft
+		return strPad(s1, tk.ToInt(argAt(argsA, 1, 0), 0), tk.ToStr(argAt(argsA, 2, " ")), true)
+	case 1556: // padRight
+		return strPad(s1, tk.ToInt(argAt(argsA, 1, 0), 0), tk.ToStr(argAt(argsA, 2, " ")), false)
+	case 1571: // split
+		return strings.SplitN(s1, tk.ToStr(argAt(argsA, 1, "")), tk.ToInt(argAt(argsA, 2, -1), -1))
+	case 1573: // splitLines
+		return tk.SplitLines(s1)
+	case 1575: // join
+		if nv, ok := argAt(argsA, 0, nil).(*LineIterator); ok {
+			linesT, errT := nv.ReadAll()
+
+			if errT != nil {
+				return errT
+			}
 
-		if errT != nil {
-			return p.Errf("%v", errT)
+			return strings.Join(linesT, tk.ToStr(argAt(argsA, 1, "")))
 		}
 
-		return ""
+		return strings.Join(toStrList(argAt(argsA, 0, []string{})), tk.ToStr(argAt(argsA, 1, "")))
+	}
 
-	case 224: // pop
-		pr := instrT.Params[0]
+	return fmt.Errorf("unknown string instr: %v", codeA)
+}
 
-		p.SetVar(pr, p.Stack.Pop())
+type instrOpInfo struct {
+	Op       OpCodeNum
+	ParamLen int // minimal parameter count, including the result parameter
+}
 
-		return ""
-	case 401: // assign/=
-		if instrT.ParamLen < 2 {
-			return fmt.Errorf("not enough parameters")
-		}
+var strInstrInfoMapG = map[int]instrOpInfo{
+	1501: {OpStrAdd, 2},
+	1503: {OpStrLen, 2},
+	1511: {OpTrim, 2},
+	1521: {OpToUpper, 2},
+	1522: {OpToLower, 2},
+	1531: {OpContains, 3},
+	1533: {OpStartsWith, 3},
+	1535: {OpEndsWith, 3},
+	1537: {OpIndexOf, 3},
+	1541: {OpReplace, 4},
+	1551: {OpSubStr, 3},
+	1553: {OpRepeat, 3},
+	1555: {OpPadLeft, 3},
+	1556: {OpPadRight, 3},
+	1571: {OpSplit, 3},
+	1573: {OpSplitLines, 2},
+	1575: {OpJoin, 3},
+}
 
-		pr := instrT.Params[0]
+// TestByText checks if 2 string values are equal for test purpose, argsA: the value, the expected value, [the test sequence number or name], [the test description]
+func (p *VM) TestByText(argsA []interface{}) error {
+	v1 := tk.ToStr(argAt(argsA, 0, ""))
+	v2 := tk.ToStr(argAt(argsA, 1, ""))
 
-		var valueT interface{}
+	var v3 string
+	var v4 string
 
-		valueT = p.GetVarValue(instrT.Params[1])
+	if len(argsA) > 3 {
+		v3 = tk.ToStr(argsA[2])
+		v4 = "(" + tk.ToStr(argsA[3]) + ")"
+	} else if len(argsA) > 2 {
+		v3 = tk.ToStr(argsA[2])
+	} else {
+		v3 = tk.ToStr(p.Seq.Get())
+	}
 
-		p.SetVar(pr, valueT)
+	if v1 != v2 {
+		return fmt.Errorf("test %v%v failed: (pos: %v) %#v <-> %#v\n-----\n%v\n-----\n%v", v3, v4, tk.FindFirstDiffIndex(v1, v2), v1, v2, v1, v2)
+	}
 
-		return ""
+	p.Print(fmt.Sprintf("test %v%v passed\n", v3, v4))
 
-	case 610: // if
-		// tk.Plv(instrT)
-		if instrT.ParamLen < 2 {
-			return p.Errf("not enough parameters")
-		}
+	return nil
+}
 
-		var condT bool
-		var v2 interface{}
-		var v2o interface{}
-		var ok0 bool
+// system related
 
-		var elseLabelIntT int = -1
+// Sleep sleeps for the seconds, and could be interrupted by the context of the VM(returns *CancelError), or by a trapped signal(returns nil, and the handler runs next)
+func (p *VM) Sleep(secondsA float64) error {
+	p.Flush()
 
-		if instrT.ParamLen > 2 {
-			elseLabelT := p.GetLabelIndex(p.GetVarValue(instrT.Params[2]))
+	if secondsA <= 0 {
+		return nil
+	}
 
-			if elseLabelT < 0 {
-				return p.Errf("invalid label: %v", elseLabelT)
-			}
+	timerT := time.NewTimer(time.Duration(secondsA * float64(time.Second)))
+	defer timerT.Stop()
 
-			elseLabelIntT = elseLabelT
+	ctxT, cancelT := p.waitContext()
+	defer cancelT()
+
+	select {
+	case <-timerT.C:
+		return nil
+	case <-ctxT.Done():
+		if p.Ctx.Err() != nil {
+			return &CancelError{Err: p.Ctx.Err()}
 		}
 
-		v2o = instrT.Params[1]
+		// interrupted by a trapped signal
+		return nil
+	}
+}
 
-		v2 = p.GetVarValue(instrT.Params[1])
+// EvalEnvInstr runs the environment variable related instructions with the resolved parameters(without the result one)
+func EvalEnvInstr(codeA int, argsA []interface{}) interface{} {
+	s1 := tk.ToStr(argAt(argsA, 0, ""))
 
-		// tk.Plv(instrT)
-		tmpv := p.GetVarValue(instrT.Params[0])
-		if DebugG {
-			tk.Pl("if %v -> %v", instrT.Params[0], tmpv)
-		}
+	switch codeA {
+	case 20521: // getEnv
+		rs, ok := os.LookupEnv(s1)
+
+		if !ok {
+			return tk.ToStr(argAt(argsA, 1, ""))
+		}
+
+		return rs
+	case 20522: // setEnv
+		return errToResult(os.Setenv(s1, tk.ToStr(argAt(argsA, 1, ""))))
+	case 20523: // removeEnv
+		return errToResult(os.Unsetenv(s1))
+	case 20525: // getEnvList
+		return os.Environ()
+	case 20527: // expandEnv
+		return os.ExpandEnv(s1)
+	}
+
+	return fmt.Errorf("unknown env instr: %v", codeA)
+}
+
+var envInstrInfoMapG = map[int]instrOpInfo{
+	20521: {OpGetEnv, 2},
+	20522: {OpSetEnv, 3},
+	20523: {OpRemoveEnv, 2},
+	20525: {OpGetEnvList, 1},
+	20527: {OpExpandEnv, 2},
+}
+
+// CmdOptions is the options for running an os command
+type CmdOptions struct {
+	Dir     string
+	Env     []string
+	Stdin   interface{}
+	Timeout float64
+}
+
+// ParseCmdArgs separates the command and its arguments from the options like -cwd=..., -env=K=V, -stdin=... and -timeout=5(the values are literal), the options should be before the command, so the arguments of the command are never treated as options
+func (p *VM) ParseCmdArgs(argsA []interface{}) ([]string, *CmdOptions) {
+	cmdArgsT := make([]string, 0, len(argsA))
+
+	optsT := &CmdOptions{}
+
+	for _, v := range argsA {
+		s1 := tk.ToStr(v)
+
+		if len(cmdArgsT) < 1 {
+			if strings.HasPrefix(s1, "-cwd=") {
+				optsT.Dir = s1[5:]
+				continue
+			} else if strings.HasPrefix(s1, "-env=") {
+				optsT.Env = append(optsT.Env, s1[5:])
+				continue
+			} else if strings.HasPrefix(s1, "-stdin=") {
+				optsT.Stdin = s1[7:]
+				continue
+			} else if strings.HasPrefix(s1, "-timeout=") {
+				optsT.Timeout = tk.ToFloat(s1[9:], 0)
+				continue
+			}
+		}
+
+		cmdArgsT = append(cmdArgsT, s1)
+	}
+
+	return cmdArgsT, optsT
+}
+
+// the time to wait for the output pipes to be closed after the command exits or is killed, the pipes may be held by the child processes of the command
+const cmdWaitDelay = time.Second
+
+// ignoreWaitDelay ignores the error that the output pipes are still held(by the child processes) after the command exits, the output is taken until then
+func ignoreWaitDelay(errA error) error {
+	if errors.Is(errA, exec.ErrWaitDelay) {
+		return nil
+	}
+
+	return errA
+}
+
+func newCmd(ctxA context.Context, cmdArgsA []string, optsA *CmdOptions) *exec.Cmd {
+	cmdT := exec.CommandContext(ctxA, cmdArgsA[0], cmdArgsA[1:]...)
+
+	cmdT.WaitDelay = cmdWaitDelay
+
+	cmdT.Dir = optsA.Dir
+
+	if len(optsA.Env) > 0 {
+		cmdT.Env = append(os.Environ(), optsA.Env...)
+	}
+
+	if optsA.Stdin != nil {
+		if nv, ok := optsA.Stdin.([]byte); ok {
+			cmdT.Stdin = bytes.NewReader(nv)
+		} else {
+			cmdT.Stdin = strings.NewReader(tk.ToStr(optsA.Stdin))
+		}
+	}
+
+	return cmdT
+}
+
+// limitedBuffer keeps the output of a command up to max bytes(no limit if max < 1), onExceed is called once if more is written, the rest is dropped without failing the writes
+type limitedBuffer struct {
+	// not embedded, so io.Copy could not bypass Write by ReadFrom
+	buf bytes.Buffer
+
+	max      int
+	total    int
+	onExceed func()
+}
+
+func (p *limitedBuffer) Write(bufA []byte) (int, error) {
+	p.total += len(bufA)
+
+	if p.max > 0 && p.total > p.max {
+		if p.onExceed != nil {
+			p.onExceed()
+			p.onExceed = nil
+		}
+
+		return len(bufA), nil
+	}
+
+	return p.buf.Write(bufA)
+}
+
+func (p *limitedBuffer) String() string {
+	return p.buf.String()
+}
+
+// exceeded checks if more than max bytes are written, and sets the limit error
+func (p *limitedBuffer) exceeded(vmA *VM) error {
+	if p.max > 0 && p.total > p.max {
+		return vmA.setLimitErr("MaxStrLen", p.total, p.max)
+	}
+
+	return nil
+}
+
+// SystemCmd runs the command and returns the combined output as tk.SystemCmd, but the command is killed if the VM's context is done(the result is a *CancelError then) or a trapped signal is received
+func (p *VM) SystemCmd(cmdA string, argsA ...string) interface{} {
+	ctxT, cancelT := p.waitContext()
+	defer cancelT()
+
+	cmdT := newCmd(ctxT, append([]string{cmdA}, argsA...), &CmdOptions{})
+
+	p.Flush()
+
+	// the command is killed if the output exceeds MaxStrLen
+	outputT := &limitedBuffer{max: p.Limits.MaxStrLen, onExceed: cancelT}
+
+	cmdT.Stdout = outputT
+	cmdT.Stderr = outputT
+
+	errT := ignoreWaitDelay(cmdT.Run())
+
+	if errT != nil && p.Ctx.Err() != nil {
+		return &CancelError{Err: p.Ctx.Err()}
+	}
+
+	if errT := outputT.exceeded(p); errT != nil {
+		return errT
+	}
+
+	if errT != nil && ctxT.Err() != nil {
+		errT = ErrInterrupted
+	}
+
+	if errT != nil {
+		return tk.ErrStrf("%v", errT)
+	}
+
+	return outputT.String()
+}
+
+// SystemCmdEx runs the command with the options, argsA: the command, [arguments and options]..., the command is killed if the VM's context is done(the result is a *CancelError then) or a trapped signal is received
+func (p *VM) SystemCmdEx(argsA []interface{}) interface{} {
+	cmdArgsT, optsT := p.ParseCmdArgs(argsA)
+
+	if len(cmdArgsT) < 1 {
+		return fmt.Errorf("command not specified")
+	}
+
+	waitCtxT, waitCancelT := p.waitContext()
+	defer waitCancelT()
+
+	ctxT := waitCtxT
+
+	if optsT.Timeout > 0 {
+		var cancelT context.CancelFunc
+
+		ctxT, cancelT = context.WithTimeout(waitCtxT, time.Duration(optsT.Timeout*float64(time.Second)))
+		defer cancelT()
+	}
+
+	cmdT := newCmd(ctxT, cmdArgsT, optsT)
+
+	p.Flush()
+
+	// the command is killed if the output exceeds MaxStrLen
+	stdoutT := &limitedBuffer{max: p.Limits.MaxStrLen, onExceed: waitCancelT}
+	stderrT := &limitedBuffer{max: p.Limits.MaxStrLen, onExceed: waitCancelT}
+
+	cmdT.Stdout = stdoutT
+	cmdT.Stderr = stderrT
+
+	startTimeT := time.Now()
+
+	errT := ignoreWaitDelay(cmdT.Run())
+
+	durationT := time.Since(startTimeT).Seconds()
+
+	if errT != nil && p.Ctx.Err() != nil {
+		return &CancelError{Err: p.Ctx.Err()}
+	}
+
+	for _, v := range []*limitedBuffer{stdoutT, stderrT} {
+		if errT := v.exceeded(p); errT != nil {
+			return errT
+		}
+	}
+
+	exitCodeT := 0
+	timedOutT := ctxT.Err() == context.DeadlineExceeded
+
+	if errT != nil && !timedOutT && ctxT.Err() != nil {
+		return ErrInterrupted
+	}
+
+	if errT != nil {
+		exitErrT, ok := errT.(*exec.ExitError)
+
+		if !ok && !timedOutT {
+			return errT
+		}
+
+		exitCodeT = -1
+
+		if ok && !timedOutT {
+			exitCodeT = exitErrT.ExitCode()
+		}
+	}
+
+	return map[string]interface{}{"stdout": stdoutT.String(), "stderr": stderrT.String(), "exitCode": exitCodeT, "duration": durationT, "timeout": timedOutT}
+}
+
+// the maximum count of the lines kept in a LineQueue, the oldest ones are dropped if the queue is not drained
+const lineQueueSize = 10000
+
+// the maximum length of a line written to a LineQueue, a longer one is split
+const lineQueueMaxLineLen = 16 * 1024 * 1024
+
+// LineQueue is a bounded queue of the output lines of a process, it keeps the latest lineQueueSize lines and counts the dropped ones
+type LineQueue struct {
+	lock    sync.Mutex
+	lines   []string
+	dropped int
+	closed  bool
+	notifyC chan struct{}
+
+	// the incomplete line written by Write
+	partial []byte
+}
+
+func NewLineQueue() *LineQueue {
+	return &LineQueue{notifyC: make(chan struct{}, 1)}
+}
+
+func (p *LineQueue) notify() {
+	select {
+	case p.notifyC <- struct{}{}:
+	default:
+	}
+}
+
+func (p *LineQueue) Push(lineA string) {
+	p.lock.Lock()
+	p.push(lineA)
+	p.lock.Unlock()
+
+	p.notify()
+}
+
+func (p *LineQueue) push(lineA string) {
+	if len(p.lines) >= lineQueueSize {
+		p.lines = p.lines[1:]
+		p.dropped++
+	}
+
+	p.lines = append(p.lines, lineA)
+}
+
+// Write splits the output to lines(without the line endings) and pushes them, so the queue could be used as the stdout/stderr of a command
+func (p *LineQueue) Write(bufA []byte) (int, error) {
+	p.lock.Lock()
+
+	p.partial = append(p.partial, bufA...)
+
+	for {
+		idxT := bytes.IndexByte(p.partial, '\n')
+
+		if idxT < 0 {
+			if len(p.partial) >= lineQueueMaxLineLen {
+				p.push(string(p.partial))
+				p.partial = nil
+			}
+
+			break
+		}
+
+		p.push(strings.TrimSuffix(string(p.partial[:idxT]), "\r"))
+		p.partial = p.partial[idxT+1:]
+	}
+
+	p.lock.Unlock()
+
+	p.notify()
+
+	return len(bufA), nil
+}
+
+// Dropped gets the count of the lines dropped since the queue is full
+func (p *LineQueue) Dropped() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.dropped
+}
+
+// Close closes the queue, the incomplete line written is pushed
+func (p *LineQueue) Close() {
+	p.lock.Lock()
+
+	if len(p.partial) > 0 {
+		p.push(strings.TrimSuffix(string(p.partial), "\r"))
+		p.partial = nil
+	}
+
+	p.closed = true
+	p.lock.Unlock()
+
+	p.notify()
+}
+
+// Pop waits for the next line, returns io.EOF if the queue is closed and empty, timeoutA <= 0 means no timeout
+func (p *LineQueue) Pop(ctxA context.Context, timeoutA float64) (string, error) {
+	var timeoutC <-chan time.Time
+
+	if timeoutA > 0 {
+		timerT := time.NewTimer(time.Duration(timeoutA * float64(time.Second)))
+		defer timerT.Stop()
+
+		timeoutC = timerT.C
+	}
+
+	for {
+		p.lock.Lock()
+
+		if len(p.lines) > 0 {
+			lineT := p.lines[0]
+			p.lines = p.lines[1:]
+
+			p.lock.Unlock()
+
+			return lineT, nil
+		}
+
+		closedT := p.closed
+
+		p.lock.Unlock()
+
+		if closedT {
+			return "", io.EOF
+		}
+
+		select {
+		case <-p.notifyC:
+		case <-timeoutC:
+			return "", fmt.Errorf("timeout")
+		case <-ctxA.Done():
+			return "", ctxA.Err()
+		}
+	}
+}
+
+// ProcessHandle is the handle of a process started by startProcess
+type ProcessHandle struct {
+	Cmd   *exec.Cmd
+	Stdin io.WriteCloser
+
+	Stdout *LineQueue
+	Stderr *LineQueue
+
+	StartTime time.Time
+	Duration  float64
+	ExitCode  int
+
+	DoneC chan struct{}
+}
+
+// StartProcess starts the process in background, the process will be killed if the context of the VM is done
+func (p *VM) StartProcess(argsA []interface{}) interface{} {
+	cmdArgsT, optsT := p.ParseCmdArgs(argsA)
+
+	if len(cmdArgsT) < 1 {
+		return fmt.Errorf("command not specified")
+	}
+
+	cmdT := newCmd(p.Ctx, cmdArgsT, optsT)
+
+	handleT := &ProcessHandle{Cmd: cmdT, Stdout: NewLineQueue(), Stderr: NewLineQueue(), DoneC: make(chan struct{})}
+
+	var errT error
+
+	if cmdT.Stdin == nil {
+		handleT.Stdin, errT = cmdT.StdinPipe()
+
+		if errT != nil {
+			return errT
+		}
+	}
+
+	// Wait copies the output to the queues, and stops waiting for the pipes held by the child processes of the command after WaitDelay
+	cmdT.Stdout = handleT.Stdout
+	cmdT.Stderr = handleT.Stderr
+
+	handleT.StartTime = time.Now()
+
+	errT = cmdT.Start()
+
+	if errT != nil {
+		return errT
+	}
+
+	go func() {
+		errT := ignoreWaitDelay(cmdT.Wait())
+
+		handleT.Stdout.Close()
+		handleT.Stderr.Close()
+
+		handleT.Duration = time.Since(handleT.StartTime).Seconds()
+
+		if errT != nil {
+			handleT.ExitCode = -1
+
+			if exitErrT, ok := errT.(*exec.ExitError); ok {
+				handleT.ExitCode = exitErrT.ExitCode()
+			}
+		}
+
+		close(handleT.DoneC)
+	}()
+
+	return handleT
+}
+
+// EvalProcessInstr runs the process related instructions(except startProcess) with the resolved parameters(without the result one)
+func (p *VM) EvalProcessInstr(codeA int, argsA []interface{}) interface{} {
+	handleT, ok := argAt(argsA, 0, nil).(*ProcessHandle)
+
+	if !ok {
+		return fmt.Errorf("invalid process handle: %T", argAt(argsA, 0, nil))
+	}
+
+	var timeoutT float64
+	var ifStderrT bool
+	var ifCloseT bool
+
+	restT := make([]interface{}, 0, len(argsA))
+
+	for _, v := range argsA[1:] {
+		if nv, ok := v.(string); ok {
+			if strings.HasPrefix(nv, "-timeout=") {
+				timeoutT = tk.ToFloat(nv[9:], 0)
+				continue
+			} else if nv == "-stderr" {
+				ifStderrT = true
+				continue
+			} else if nv == "-close" {
+				ifCloseT = true
+				continue
+			}
+		}
+
+		restT = append(restT, v)
+	}
+
+	p.Flush()
+
+	ctxT, cancelT := p.waitContext()
+	defer cancelT()
+
+	switch codeA {
+	case 20613: // waitProcess
+		var timeoutC <-chan time.Time
+
+		if timeoutT > 0 {
+			timerT := time.NewTimer(time.Duration(timeoutT * float64(time.Second)))
+			defer timerT.Stop()
+
+			timeoutC = timerT.C
+		}
+
+		select {
+		case <-handleT.DoneC:
+			return map[string]interface{}{"exitCode": handleT.ExitCode, "duration": handleT.Duration, "dropped": handleT.Stdout.Dropped() + handleT.Stderr.Dropped()}
+		case <-timeoutC:
+			return fmt.Errorf("timeout")
+		case <-ctxT.Done():
+			return p.waitErr()
+		}
+	case 20615: // killProcess
+		select {
+		case <-handleT.DoneC:
+			return ""
+		default:
+		}
+
+		return errToResult(handleT.Cmd.Process.Kill())
+	case 20617: // readProcessOutput
+		queueT := handleT.Stdout
+
+		if ifStderrT {
+			queueT = handleT.Stderr
+		}
+
+		lineT, errT := queueT.Pop(ctxT, timeoutT)
+
+		if errT != nil {
+			if ctxT.Err() != nil {
+				return p.waitErr()
+			}
+
+			return errT
+		}
+
+		return lineT
+	case 20619: // writeProcessInput
+		if handleT.Stdin == nil {
+			return fmt.Errorf("stdin of the process is not available")
+		}
+
+		for _, v := range restT {
+			var errT error
+
+			if nv, ok := v.([]byte); ok {
+				_, errT = handleT.Stdin.Write(nv)
+			} else {
+				_, errT = io.WriteString(handleT.Stdin, tk.ToStr(v))
+			}
+
+			if errT != nil {
+				return errT
+			}
+		}
+
+		if ifCloseT {
+			return errToResult(handleT.Stdin.Close())
+		}
+
+		return ""
+	}
+
+	return fmt.Errorf("unknown process instr: %v", codeA)
+}
+
+var processInstrInfoMapG = map[int]instrOpInfo{
+	20611: {OpStartProcess, 2},
+	20613: {OpWaitProcess, 2},
+	20615: {OpKillProcess, 2},
+	20617: {OpReadProcessOutput, 2},
+	20619: {OpWriteProcessInput, 3},
+}
+
+var signalMapG = map[string]os.Signal{
+	"SIGINT":  os.Interrupt,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGQUIT": syscall.SIGQUIT,
+}
+
+// Trap sets the handler(code pointer of the running engine) for the signal
+func (p *VM) Trap(nameA string, pointerA int) error {
+	nameT := strings.ToUpper(strings.TrimSpace(nameA))
+
+	if !strings.HasPrefix(nameT, "SIG") {
+		nameT = "SIG" + nameT
+	}
+
+	signalT, ok := signalMapG[nameT]
+
+	if !ok {
+		return fmt.Errorf("unsupported signal: %v", nameA)
+	}
+
+	if pointerA < 0 {
+		return fmt.Errorf("invalid label: %v", pointerA)
+	}
+
+	if p.signalC == nil {
+		p.signalC = make(chan os.Signal, 1)
+		p.Traps = make(map[string]int)
+	}
+
+	p.Traps[signalT.String()] = pointerA
+
+	signal.Notify(p.signalC, signalT)
+
+	return nil
+}
+
+// CheckTraps jumps to the handler if a trapped signal is received, called at the instruction boundaries, the function calls are unwound(with their deferred instructions run) before jumping, the signal is kept until returning to the top level if in a CallFunc
+func (p *VM) CheckTraps() error {
+	if p.signalC == nil || p.nestedCalls > 0 {
+		return nil
+	}
+
+	select {
+	case signalT := <-p.signalC:
+		pointerT, ok := p.Traps[signalT.String()]
+
+		if !ok {
+			return nil
+		}
+
+		for p.FuncStack.Size() > 1 {
+			rs := p.FuncStack.Pop().(*FuncContext).RunDefer(p)
+
+			if tk.IsError(rs) {
+				return fmt.Errorf("[%v](qxlang) runtime error: %v", tk.GetNowTimeStringFormal(), rs)
+			}
+		}
+
+		p.PointerStack = tk.NewSimpleStack(10, tk.Undefined)
+		p.InternalStack = tk.NewSimpleStack(10, tk.Undefined)
+
+		p.CodePointer = pointerT
+	default:
+	}
+
+	return nil
+}
+
+// ErrInterrupted is the result of the blocking instructions interrupted by a trapped signal
+var ErrInterrupted = errors.New("interrupted by signal")
+
+// waitErr gets the error of the blocking operation cancelled by the context from waitContext
+func (p *VM) waitErr() error {
+	if errT := p.Ctx.Err(); errT != nil {
+		return errT
+	}
+
+	return ErrInterrupted
+}
+
+// waitContext gets the context for the blocking operations, it's derived from p.Ctx and also cancelled if a trapped signal is received(the signal is kept for CheckTraps), cancelR should be called after the operation
+func (p *VM) waitContext() (ctxR context.Context, cancelR context.CancelFunc) {
+	ctxR, cancelR = context.WithCancel(p.Ctx)
+
+	if p.signalC == nil {
+		return
+	}
+
+	signalC := p.signalC
+
+	go func() {
+		select {
+		case signalT := <-signalC:
+			// put it back for CheckTraps, there is already one pending if the channel is full
+			select {
+			case signalC <- signalT:
+			default:
+			}
+
+			cancelR()
+		case <-ctxR.Done():
+		}
+	}()
+
+	return
+}
+
+// StopTraps stops receiving the trapped signals
+func (p *VM) StopTraps() {
+	if p.signalC == nil {
+		return
+	}
+
+	signal.Stop(p.signalC)
+
+	p.signalC = nil
+	p.Traps = nil
+}
+
+// command-line related
+
+// ParamSpec is an option declared by the params instruction, in the form "-name:type=default help text"
+type ParamSpec struct {
+	Name       string
+	Type       string
+	Default    string
+	HasDefault bool
+	Help       string
+}
+
+func ParseParamSpec(specA string) ParamSpec {
+	specT := strings.TrimSpace(specA)
+
+	rs := ParamSpec{Type: "string"}
+
+	headT := specT
+
+	idxT := strings.IndexAny(specT, " \t")
+
+	if idxT >= 0 {
+		headT = specT[:idxT]
+		rs.Help = strings.TrimSpace(specT[idxT+1:])
+	}
+
+	idxT = strings.Index(headT, "=")
+
+	if idxT >= 0 {
+		rs.Default = headT[idxT+1:]
+		rs.HasDefault = true
+		headT = headT[:idxT]
+	}
+
+	idxT = strings.Index(headT, ":")
+
+	if idxT >= 0 {
+		rs.Type = strings.ToLower(headT[idxT+1:])
+		headT = headT[:idxT]
+	}
+
+	rs.Name = strings.TrimLeft(headT, "-")
+
+	return rs
+}
+
+// getSwitchValue gets the value of the switch like -name=value or --name=value, a switch without value such as -name is also treated as existing with an empty value
+func getSwitchValue(argsA []string, nameA string) (string, bool) {
+	for _, v := range argsA {
+		if !strings.HasPrefix(v, "-") {
+			continue
+		}
+
+		v = strings.TrimLeft(v, "-")
+
+		if v == nameA {
+			return "", true
+		}
+
+		if strings.HasPrefix(v, nameA+"=") {
+			return v[len(nameA)+1:], true
+		}
+	}
+
+	return "", false
+}
+
+// GetParamsValues gets the values of the options declared by the params instruction, argsA: the command-line arguments of the script(without the executable and the script path), specsA: the option declarations, the arguments other than the declared options(such as -5) are in the key "args", and so are all the ones after "--"
+func GetParamsValues(argsA []string, specsA []string) interface{} {
+	rs := make(map[string]interface{}, len(specsA)+1)
+
+	optArgsT := argsA
+	var restArgsT []string
+
+	for i, v := range argsA {
+		if v == "--" {
+			optArgsT = argsA[:i]
+			restArgsT = argsA[i+1:]
+			break
+		}
+	}
+
+	namesT := make(map[string]bool, len(specsA))
+
+	for _, v := range specsA {
+		specT := ParseParamSpec(v)
+
+		namesT[specT.Name] = true
+
+		valueT, ok := getSwitchValue(optArgsT, specT.Name)
+
+		if specT.Type == "bool" {
+			if !ok {
+				rs[specT.Name] = specT.HasDefault && tk.ToBool(specT.Default)
+				continue
+			}
+
+			rs[specT.Name] = valueT == "" || tk.ToBool(valueT)
+			continue
+		}
+
+		if !ok {
+			valueT = specT.Default
+		}
+
+		switch specT.Type {
+		case "int":
+			c1T, errT := strconv.Atoi(valueT)
+
+			if errT != nil {
+				return fmt.Errorf("invalid integer value for -%v: %v", specT.Name, valueT)
+			}
+
+			rs[specT.Name] = c1T
+		case "float":
+			c1T, errT := strconv.ParseFloat(valueT, 64)
+
+			if errT != nil {
+				return fmt.Errorf("invalid float value for -%v: %v", specT.Name, valueT)
+			}
+
+			rs[specT.Name] = c1T
+		case "string":
+			rs[specT.Name] = valueT
+		default:
+			return fmt.Errorf("unsupported type of -%v: %v", specT.Name, specT.Type)
+		}
+	}
+
+	paramsT := make([]string, 0, len(argsA))
+
+	for _, v := range optArgsT {
+		if strings.HasPrefix(v, "-") {
+			nameT, _, _ := strings.Cut(strings.TrimLeft(v, "-"), "=")
+
+			if namesT[nameT] {
+				continue
+			}
+		}
+
+		paramsT = append(paramsT, v)
+	}
+
+	rs["args"] = append(paramsT, restArgsT...)
+
+	return rs
+}
+
+// GetParamsHelp generates the help text from the constant declarations of the params instruction, returns false if not found
+func (p *ByteCode) GetParamsHelp() (string, bool) {
+	for _, instrT := range p.InstrList {
+		if instrT.Code != 20311 || len(instrT.Params) < 1 {
+			continue
+		}
+
+		var sb strings.Builder
+
+		sb.WriteString("Usage: qx <script> [options] [args]\n\nOptions:\n")
+
+		for _, v := range instrT.Params[1:] {
+			if v.Ref != -3 {
+				continue
+			}
+
+			specT := ParseParamSpec(tk.ToStr(v.Value))
+
+			nameT := "-" + specT.Name
+
+			if specT.Type != "bool" {
+				nameT += "=<" + specT.Type + ">"
+			}
+
+			helpT := specT.Help
+
+			if specT.HasDefault {
+				helpT += " (default: " + specT.Default + ")"
+			}
+
+			sb.WriteString(fmt.Sprintf("  %-24s %v\n", nameT, strings.TrimSpace(helpT)))
+		}
+
+		return sb.String(), true
+	}
+
+	return "", false
+}
+
+// EvalArgsInstr runs the command-line related instructions with the resolved parameters(without the result one)
+func (p *VM) EvalArgsInstr(codeA int, argsA []interface{}) interface{} {
+	cmdArgsT := toStrList(p.GetGlobal("argsG"))
+
+	switch codeA {
+	case 20301: // getParam
+		return tk.GetParam(cmdArgsT, tk.ToInt(argAt(argsA, 0, 0), 0), tk.ToStr(argAt(argsA, 1, "")))
+	case 20303: // getSwitch
+		return tk.GetSwitch(cmdArgsT, tk.ToStr(argAt(argsA, 0, "")), tk.ToStr(argAt(argsA, 1, "")))
+	case 20305: // ifSwitchExists
+		return tk.IfSwitchExists(cmdArgsT, tk.ToStr(argAt(argsA, 0, "")))
+	case 20311: // params
+		offsetT := p.ArgsOffset
+
+		if offsetT > len(cmdArgsT) {
+			offsetT = len(cmdArgsT)
+		}
+
+		return GetParamsValues(cmdArgsT[offsetT:], toStrList(argsA))
+	}
+
+	return fmt.Errorf("unknown command-line instr: %v", codeA)
+}
+
+var argsInstrInfoMapG = map[int]instrOpInfo{
+	20301: {OpGetParam, 2},
+	20303: {OpGetSwitch, 2},
+	20305: {OpIfSwitchExists, 2},
+	20311: {OpParams, 1},
+}
+
+// output related
+
+// outputWriter counts the bytes written to Stdout/Stderr, and truncates the output at Limits.MaxOutputBytes
+type outputWriter struct {
+	vm     *VM
+	writer io.Writer
+}
+
+func (p *outputWriter) Write(bufA []byte) (int, error) {
+	// the child VMs started by go write to the same writers
+	if p.vm.outputLock != nil {
+		p.vm.outputLock.Lock()
+		defer p.vm.outputLock.Unlock()
+	}
+
+	maxT := p.vm.Limits.MaxOutputBytes
+
+	outputBytesT := int(p.vm.budget.outputBytes.Load())
+
+	if maxT > 0 && outputBytesT+len(bufA) > maxT {
+		restT := maxT - outputBytesT
+
+		if restT < 0 {
+			restT = 0
+		}
+
+		n, errT := p.writer.Write(bufA[:restT])
+
+		p.vm.budget.outputBytes.Add(int64(n))
+
+		if errT != nil {
+			return n, errT
+		}
+
+		return n, p.vm.setLimitErr("MaxOutputBytes", outputBytesT+len(bufA), maxT)
+	}
+
+	n, errT := p.writer.Write(bufA)
+
+	p.vm.budget.outputBytes.Add(int64(n))
+
+	return n, errT
+}
+
+// GetStdout gets the buffered writer of the VM's stdout
+func (p *VM) GetStdout() *bufio.Writer {
+	if p.stdoutWriter == nil || p.stdoutTarget != p.Stdout {
+		if p.stdoutWriter != nil {
+			p.stdoutWriter.Flush()
+		}
+
+		p.stdoutWriter = bufio.NewWriter(&outputWriter{vm: p, writer: p.Stdout})
+		p.stdoutTarget = p.Stdout
+	}
+
+	return p.stdoutWriter
+}
+
+// GetStderr gets the buffered writer of the VM's stderr
+func (p *VM) GetStderr() *bufio.Writer {
+	if p.stderrWriter == nil || p.stderrTarget != p.Stderr {
+		if p.stderrWriter != nil {
+			p.stderrWriter.Flush()
+		}
+
+		p.stderrWriter = bufio.NewWriter(&outputWriter{vm: p, writer: p.Stderr})
+		p.stderrTarget = p.Stderr
+	}
+
+	return p.stderrWriter
+}
+
+// Flush flushes the buffered output
+func (p *VM) Flush() {
+	if p.stdoutWriter != nil {
+		p.stdoutWriter.Flush()
+	}
+
+	if p.stderrWriter != nil {
+		p.stderrWriter.Flush()
+	}
+}
+
+// Print writes the string to the VM's stdout, the buffered output is flushed if the string contains a line end, so the progress lines are shown while running
+func (p *VM) Print(strA string) {
+	writerT := p.GetStdout()
+
+	writerT.WriteString(strA)
+
+	if strings.Contains(strA, "\n") {
+		writerT.Flush()
+	}
+}
+
+// Pln prints the values to the VM's stdout as fmt.Println
+func (p *VM) Pln(argsA ...interface{}) {
+	p.Print(fmt.Sprintln(argsA...))
+}
+
+// Plo prints the values with their types to the VM's stdout
+func (p *VM) Plo(argsA ...interface{}) {
+	var sb strings.Builder
+
+	for i, v := range argsA {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+
+		fmt.Fprintf(&sb, "(%T)%v", v, v)
+	}
+
+	sb.WriteString("\n")
+
+	p.Print(sb.String())
+}
+
+// PlErr prints the line to the VM's stderr, the stdout is flushed first to keep the order of the output
+func (p *VM) PlErr(strA string) {
+	if p.stdoutWriter != nil {
+		p.stdoutWriter.Flush()
+	}
+
+	writerT := p.GetStderr()
+
+	fmt.Fprintln(writerT, strA)
+
+	writerT.Flush()
+}
+
+// input related
+
+// sharedStdin is the buffered reader of the input, shared by the VM and its child VMs started by go
+type sharedStdin struct {
+	lock   sync.Mutex
+	reader *bufio.Reader
+	source io.Reader
+
+	ctxReader *ctxReader
+
+	// the input read before the last read was cancelled
+	partial string
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+// ctxReader reads the source in another goroutine, so a blocked read could be given up when the context is done, the data read after that is kept for the next read
+type ctxReader struct {
+	source io.Reader
+	ctx    context.Context
+
+	resultC chan readResult
+	pending bool
+
+	data []byte
+	err  error
+}
+
+func newCtxReader(sourceA io.Reader) *ctxReader {
+	return &ctxReader{source: sourceA, ctx: context.Background(), resultC: make(chan readResult, 1)}
+}
+
+func (p *ctxReader) Read(bufA []byte) (int, error) {
+	if len(p.data) < 1 && p.err == nil {
+		if !p.pending {
+			p.pending = true
+
+			go func() {
+				bufT := make([]byte, 4096)
+
+				n, errT := p.source.Read(bufT)
+
+				p.resultC <- readResult{data: bufT[:n], err: errT}
+			}()
+		}
+
+		select {
+		case rs := <-p.resultC:
+			p.pending = false
+			p.data = rs.data
+			p.err = rs.err
+		case <-p.ctx.Done():
+			return 0, p.ctx.Err()
+		}
+	}
+
+	if len(p.data) > 0 {
+		n := copy(bufA, p.data)
+		p.data = p.data[n:]
+
+		return n, nil
+	}
+
+	errT := p.err
+	p.err = nil
+
+	return 0, errT
+}
+
+// lockStdinReader locks the shared input and gets the buffered reader of the VM's stdin, the blocked read returns the error of ctxA when it's done, p.stdin.lock should be unlocked after reading
+func (p *VM) lockStdinReader(ctxA context.Context) *bufio.Reader {
+	p.stdin.lock.Lock()
+
+	if p.stdin.reader == nil || p.stdin.source != p.Stdin {
+		p.stdin.ctxReader = newCtxReader(p.Stdin)
+		p.stdin.reader = bufio.NewReader(p.stdin.ctxReader)
+		p.stdin.source = p.Stdin
+		p.stdin.partial = ""
+	}
+
+	p.stdin.ctxReader.ctx = ctxA
+
+	return p.stdin.reader
+}
+
+// GetStdinReader gets the buffered reader of the VM's stdin, the buffer will be kept between the instructions
+func (p *VM) GetStdinReader() *bufio.Reader {
+	defer p.stdin.lock.Unlock()
+
+	return p.lockStdinReader(p.Ctx)
+}
+
+// readCancelErr gets the error of the read cancelled by the context from waitContext, the input read already should be saved to p.stdin.partial
+func (p *VM) readCancelErr() error {
+	if errT := p.Ctx.Err(); errT != nil {
+		return &CancelError{Err: errT}
+	}
+
+	return ErrInterrupted
+}
+
+// ReadLine reads a line without the line ending, returns io.EOF if no more input, the read is stopped if the VM's context is done(with a *CancelError) or a trapped signal is received(with ErrInterrupted)
+func (p *VM) ReadLine() (string, error) {
+	p.Flush()
+
+	ctxT, cancelT := p.waitContext()
+	defer cancelT()
+
+	readerT := p.lockStdinReader(ctxT)
+	defer p.stdin.lock.Unlock()
+
+	lineT, errT := readerT.ReadString('\n')
+
+	lineT = p.stdin.partial + lineT
+	p.stdin.partial = ""
+
+	if errT != nil && ctxT.Err() != nil {
+		p.stdin.partial = lineT
+
+		return "", p.readCancelErr()
+	}
+
+	if errT != nil && (errT != io.EOF || lineT == "") {
+		return "", errT
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(lineT, "\n"), "\r"), nil
+}
+
+func readLineFrom(readerA *bufio.Reader) (string, error) {
+	lineT, errT := readerA.ReadString('\n')
+
+	if errT != nil && (errT != io.EOF || lineT == "") {
+		return "", errT
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(lineT, "\n"), "\r"), nil
+}
+
+// EvalInputInstr runs the input related instructions with the resolved parameters(without the result one)
+func (p *VM) EvalInputInstr(codeA int, argsA []interface{}) interface{} {
+	switch codeA {
+	case 10501: // readLine
+		lineT, errT := p.ReadLine()
+
+		if errT != nil {
+			return errT
+		}
+
+		return lineT
+	case 10503: // readAll
+		p.Flush()
+
+		ctxT, cancelT := p.waitContext()
+		defer cancelT()
+
+		var readerT io.Reader = p.lockStdinReader(ctxT)
+		defer p.stdin.lock.Unlock()
+
+		// read at most 1 byte more than the limit to check it before building the whole string
+		if p.Limits.MaxStrLen > 0 {
+			readerT = io.LimitReader(readerT, int64(p.Limits.MaxStrLen)+1)
+		}
+
+		bufT, errT := io.ReadAll(readerT)
+
+		strT := p.stdin.partial + string(bufT)
+		p.stdin.partial = ""
+
+		if errT != nil && ctxT.Err() != nil {
+			p.stdin.partial = strT
+
+			return p.readCancelErr()
+		}
+
+		if errT != nil {
+			return errT
+		}
+
+		if p.Limits.MaxStrLen > 0 && len(strT) > p.Limits.MaxStrLen {
+			return p.setLimitErr("MaxStrLen", len(strT), p.Limits.MaxStrLen)
+		}
+
+		return strT
+	case 10505: // readLines
+		return NewLineIterator(p.ReadLine)
+	case 10511: // getInput
+		if len(argsA) > 0 {
+			p.GetStdout().WriteString(sprintfArgs(argsA))
+		}
+
+		lineT, errT := p.ReadLine()
+
+		if errT != nil {
+			return errT
+		}
+
+		return lineT
+	}
+
+	return fmt.Errorf("unknown input instr: %v", codeA)
+}
+
+// LineIterator reads the lines lazily by the function(such as VM.ReadLine), it's the result of readLines and could be used by range
+type LineIterator struct {
+	readFunc func() (string, error)
+
+	index int
+
+	line   string
+	err    error
+	peeked bool
+}
+
+func NewLineIterator(funcA func() (string, error)) *LineIterator {
+	return &LineIterator{readFunc: funcA}
+}
+
+// HasNext reads the next line in advance if not read yet, returns false if no more lines or failed to read
+func (p *LineIterator) HasNext() bool {
+	if !p.peeked {
+		p.line, p.err = p.readFunc()
+		p.peeked = true
+	}
+
+	return p.err == nil
+}
+
+// Next returns if there is a line, the index and the line
+func (p *LineIterator) Next() (bool, interface{}, interface{}) {
+	if !p.HasNext() {
+		return false, p.index, nil
+	}
+
+	p.peeked = false
+
+	indexT := p.index
+	p.index++
+
+	return true, indexT, p.line
+}
+
+// Err returns the error while reading the lines, nil if the iteration ends normally
+func (p *LineIterator) Err() error {
+	if p.err == io.EOF {
+		return nil
+	}
+
+	return p.err
+}
+
+// ReadAll reads all the remaining lines, the error is the one of Err
+func (p *LineIterator) ReadAll() ([]string, error) {
+	linesT := make([]string, 0)
+
+	for p.HasNext() {
+		_, _, lineT := p.Next()
+
+		linesT = append(linesT, lineT.(string))
+	}
+
+	return linesT, p.Err()
+}
+
+var inputInstrInfoMapG = map[int]instrOpInfo{
+	10501: {OpReadLine, 1},
+	10503: {OpReadAll, 1},
+	10505: {OpReadLines, 1},
+	10511: {OpGetInput, 1},
+}
+
+// path related
+
+// EvalPathInstr runs the path related instructions with the resolved parameters(without the result one)
+func EvalPathInstr(codeA int, argsA []interface{}) interface{} {
+	s1 := tk.ToStr(argAt(argsA, 0, ""))
+
+	switch codeA {
+	case 21901: // joinPath
+		return filepath.Join(toStrList(argsA)...)
+	case 21903: // getFileBase
+		return filepath.Base(s1)
+	case 21905: // getFileExt
+		return filepath.Ext(s1)
+	case 21907: // getFileDir
+		return filepath.Dir(s1)
+	case 21911: // absPath
+		rs, errT := filepath.Abs(s1)
+
+		if errT != nil {
+			return errT
+		}
+
+		return rs
+	case 21913: // relPath
+		rs, errT := filepath.Rel(s1, tk.ToStr(argAt(argsA, 1, "")))
+
+		if errT != nil {
+			return errT
+		}
+
+		return rs
+	case 21921: // getCurrentDir
+		rs, errT := os.Getwd()
+
+		if errT != nil {
+			return errT
+		}
+
+		return rs
+	case 21922: // setCurrentDir
+		return errToResult(os.Chdir(s1))
+	case 21925: // getHomeDir
+		rs, errT := os.UserHomeDir()
+
+		if errT != nil {
+			return errT
+		}
+
+		return rs
+	case 21927: // getTempDir
+		return os.TempDir()
+	}
+
+	return fmt.Errorf("unknown path instr: %v", codeA)
+}
+
+var pathInstrInfoMapG = map[int]instrOpInfo{
+	21901: {OpJoinPath, 2},
+	21903: {OpGetFileBase, 2},
+	21905: {OpGetFileExt, 2},
+	21907: {OpGetFileDir, 2},
+	21911: {OpAbsPath, 2},
+	21913: {OpRelPath, 3},
+	21921: {OpGetCurrentDir, 1},
+	21922: {OpSetCurrentDir, 2},
+	21925: {OpGetHomeDir, 1},
+	21927: {OpGetTempDir, 1},
+}
+
+// file related
+
+func copyFile(srcA string, dstA string) error {
+	srcT, errT := os.Open(srcA)
+
+	if errT != nil {
+		return errT
+	}
+
+	defer srcT.Close()
+
+	infoT, errT := srcT.Stat()
+
+	if errT != nil {
+		return errT
+	}
+
+	dstT, errT := os.OpenFile(dstA, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, infoT.Mode().Perm())
+
+	if errT != nil {
+		return errT
+	}
+
+	_, errT = io.Copy(dstT, srcT)
+
+	if errT != nil {
+		dstT.Close()
+		return errT
+	}
+
+	return dstT.Close()
+}
+
+// errToResult converts the error to the result value of the file related instructions
+func errToResult(errA error) interface{} {
+	if errA != nil {
+		return errA
+	}
+
+	return ""
+}
+
+// readFile reads the whole file, at most 1 byte more than MaxStrLen is read to check the limit before building the value
+func (p *VM) readFile(pathA string) ([]byte, error) {
+	if p.Limits.MaxStrLen < 1 {
+		return os.ReadFile(pathA)
+	}
+
+	fileT, errT := os.Open(pathA)
+
+	if errT != nil {
+		return nil, errT
+	}
+
+	defer fileT.Close()
+
+	bufT, errT := io.ReadAll(io.LimitReader(fileT, int64(p.Limits.MaxStrLen)+1))
+
+	if errT != nil {
+		return nil, errT
+	}
+
+	if len(bufT) > p.Limits.MaxStrLen {
+		return nil, p.setLimitErr("MaxStrLen", len(bufT), p.Limits.MaxStrLen)
+	}
+
+	return bufT, nil
+}
+
+// EvalFileInstr runs the file related instructions with the resolved parameters(without the result one), returns an error value if failed
+func (p *VM) EvalFileInstr(codeA int, argsA []interface{}) interface{} {
+	s1 := tk.ToStr(argAt(argsA, 0, ""))
+
+	switch codeA {
+	case 21101: // loadText
+		bufT, errT := p.readFile(s1)
+
+		if errT != nil {
+			return errT
+		}
+
+		return string(bufT)
+	case 21103: // saveText
+		return errToResult(os.WriteFile(tk.ToStr(argAt(argsA, 1, "")), []byte(s1), 0666))
+	case 21105: // appendText
+		fileT, errT := os.OpenFile(tk.ToStr(argAt(argsA, 1, "")), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+
+		if errT != nil {
+			return errT
+		}
+
+		_, errT = fileT.WriteString(s1)
+
+		if errT != nil {
+			fileT.Close()
+			return errT
+		}
+
+		return errToResult(fileT.Close())
+	case 21111: // loadBytes
+		bufT, errT := p.readFile(s1)
+
+		if errT != nil {
+			return errT
+		}
+
+		return bufT
+	case 21113: // saveBytes
+		bufT, ok := argAt(argsA, 0, nil).([]byte)
+
+		if !ok {
+			return fmt.Errorf("invalid parameter type, []byte expected: %T", argAt(argsA, 0, nil))
+		}
+
+		return errToResult(os.WriteFile(tk.ToStr(argAt(argsA, 1, "")), bufT, 0666))
+	case 21201: // fileExists
+		_, errT := os.Stat(s1)
+
+		return errT == nil
+	case 21203: // isDir
+		infoT, errT := os.Stat(s1)
+
+		return errT == nil && infoT.IsDir()
+	case 21205: // fileInfo
+		infoT, errT := os.Stat(s1)
+
+		if errT != nil {
+			return errT
+		}
+
+		return map[string]interface{}{"name": infoT.Name(), "size": infoT.Size(), "isDir": infoT.IsDir(), "mode": infoT.Mode().String(), "modTime": infoT.ModTime()}
+	case 21211: // listDir
+		patternT := tk.ToStr(argAt(argsA, 1, "*"))
+
+		entriesT, errT := os.ReadDir(s1)
+
+		if errT != nil {
+			return errT
+		}
+
+		rs := make([]string, 0, len(entriesT))
+
+		for _, v := range entriesT {
+			matchT, errT := filepath.Match(patternT, v.Name())
+
+			if errT != nil {
+				return errT
+			}
+
+			if matchT {
+				rs = append(rs, v.Name())
+			}
+		}
+
+		return rs
+	case 21301: // removeFile
+		return errToResult(os.Remove(s1))
+	case 21303: // renameFile
+		return errToResult(os.Rename(s1, tk.ToStr(argAt(argsA, 1, ""))))
+	case 21305: // copyFile
+		return errToResult(copyFile(s1, tk.ToStr(argAt(argsA, 1, ""))))
+	case 21311: // ensureMakeDirs
+		return errToResult(os.MkdirAll(s1, 0777))
+	}
+
+	return fmt.Errorf("unknown file instr: %v", codeA)
+}
+
+var fileInstrInfoMapG = map[int]instrOpInfo{
+	21101: {OpLoadText, 2},
+	21103: {OpSaveText, 3},
+	21105: {OpAppendText, 3},
+	21111: {OpLoadBytes, 2},
+	21113: {OpSaveBytes, 3},
+	21201: {OpFileExists, 2},
+	21203: {OpIsDir, 2},
+	21205: {OpFileInfo, 2},
+	21211: {OpListDir, 2},
+	21301: {OpRemoveFile, 2},
+	21303: {OpRenameFile, 3},
+	21305: {OpCopyFile, 3},
+	21311: {OpEnsureMakeDirs, 2},
+}
+
+// format related
+
+// SprintfX formats the string as fmt.Sprintf, or in the placeholder mode if the format string contains "{}", in which each "{}" will be replaced by the next argument as "%v"
+func SprintfX(formatA string, argsA ...interface{}) string {
+	if !strings.Contains(formatA, "{}") {
+		return fmt.Sprintf(formatA, argsA...)
+	}
+
+	listT := strings.Split(formatA, "{}")
+
+	var sb strings.Builder
+
+	for i, v := range listT {
+		sb.WriteString(v)
+
+		if i >= len(listT)-1 {
+			break
+		}
+
+		if i < len(argsA) {
+			sb.WriteString(fmt.Sprintf("%v", argsA[i]))
+		} else {
+			sb.WriteString("{}")
+		}
+	}
+
+	return sb.String()
+}
+
+// the first item of argsA is the format string
+func sprintfArgs(argsA []interface{}) string {
+	if len(argsA) < 1 {
+		return ""
+	}
+
+	return SprintfX(tk.ToStr(argsA[0]), argsA[1:]...)
+}
+
+// regex related
+
+// the maximum count of the cached patterns built at runtime(not the constant ones) for each VM
+const regexCacheSize = 256
+
+// regexLRU caches the patterns built at runtime, the least recently used one is dropped if more than regexCacheSize, shared by the VM and its child VMs started by go
+type regexLRU struct {
+	lock  sync.Mutex
+	list  *list.List
+	items map[string]*list.Element
+}
+
+type regexCacheItem struct {
+	Pattern string
+	Regexp  *regexp.Regexp
+}
+
+func newRegexLRU() *regexLRU {
+	return &regexLRU{list: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (p *regexLRU) get(patternA string) (*regexp.Regexp, error) {
+	p.lock.Lock()
+
+	if itemT, ok := p.items[patternA]; ok {
+		p.list.MoveToFront(itemT)
+		p.lock.Unlock()
+
+		return itemT.Value.(*regexCacheItem).Regexp, nil
+	}
+
+	p.lock.Unlock()
+
+	regT, errT := regexp.Compile(patternA)
+
+	if errT != nil {
+		return nil, errT
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if itemT, ok := p.items[patternA]; ok {
+		p.list.MoveToFront(itemT)
+
+		return itemT.Value.(*regexCacheItem).Regexp, nil
+	}
+
+	p.items[patternA] = p.list.PushFront(&regexCacheItem{Pattern: patternA, Regexp: regT})
+
+	for p.list.Len() > regexCacheSize {
+		lastT := p.list.Back()
+
+		p.list.Remove(lastT)
+
+		delete(p.items, lastT.Value.(*regexCacheItem).Pattern)
+	}
+
+	return regT, nil
+}
+
+// GetRegexp gets the compiled pattern, the constant ones are compiled while compiling the code, the others are compiled and cached in the VM
+func (p *VM) GetRegexp(patternA string) (*regexp.Regexp, error) {
+	if regT, ok := p.Code.regexCache[patternA]; ok {
+		return regT, nil
+	}
+
+	return p.regexLRU.get(patternA)
+}
+
+// PrepareRegexps compiles the constant pattern of a regex related instruction in advance
+func (p *ByteCode) PrepareRegexps(instrA *Instr) error {
+	infoT, ok := regInstrInfoMapG[instrA.Code]
+
+	if !ok || instrA.ParamLen < infoT.ParamLen {
+		return nil
+	}
+
+	// the pattern is always the 3rd parameter
+	vT := instrA.Params[2]
+
+	if vT.Ref != -3 {
+		return nil
+	}
+
+	patternT := tk.ToStr(vT.Value)
+
+	if _, ok := p.regexCache[patternT]; ok {
+		return nil
+	}
+
+	regT, errT := regexp.Compile(patternT)
+
+	if errT != nil {
+		return errT
+	}
+
+	p.regexCache[patternT] = regT
+
+	return nil
+}
+
+// EvalRegInstr runs the regex related instructions with the resolved parameters(without the result one)
+func (p *VM) EvalRegInstr(codeA int, argsA []interface{}) interface{} {
+	s1 := tk.ToStr(argAt(argsA, 0, ""))
+
+	regT, errT := p.GetRegexp(tk.ToStr(argAt(argsA, 1, "")))
+
+	if errT != nil {
+		return fmt.Errorf("invalid pattern: %v", errT)
+	}
+
+	switch codeA {
+	case 1611: // regMatch
+		return regT.MatchString(s1)
+	case 1613: // regFind
+		groupT := tk.ToInt(argAt(argsA, 2, 0), 0)
+
+		matchT := regT.FindStringSubmatch(s1)
+
+		if groupT < 0 || groupT >= len(matchT) {
+			return ""
+		}
+
+		return matchT[groupT]
+	case 1615: // regFindAll
+		groupT := tk.ToInt(argAt(argsA, 2, 0), 0)
+
+		matchesT := regT.FindAllStringSubmatch(s1, -1)
+
+		rs := make([]string, 0, len(matchesT))
+
+		for _, v := range matchesT {
+			if groupT >= 0 && groupT < len(v) {
+				rs = append(rs, v[groupT])
+			}
+		}
+
+		return rs
+	case 1617: // regFindGroups
+		matchT := regT.FindStringSubmatch(s1)
+
+		if matchT == nil {
+			return []string{}
+		}
+
+		return matchT
+	case 1621: // regReplace
+		return regT.ReplaceAllString(s1, tk.ToStr(argAt(argsA, 2, "")))
+	case 1631: // regSplit
+		return regT.Split(s1, tk.ToInt(argAt(argsA, 2, -1), -1))
+	}
+
+	return fmt.Errorf("unknown regex instr: %v", codeA)
+}
+
+var regInstrInfoMapG = map[int]instrOpInfo{
+	1611: {OpRegMatch, 3},
+	1613: {OpRegFind, 3},
+	1615: {OpRegFindAll, 3},
+	1617: {OpRegFindGroups, 3},
+	1621: {OpRegReplace, 4},
+	1631: {OpRegSplit, 3},
+}
+
+// GetFuncPointer gets the code pointer of the label for the running engine, -1 if not found
+func (p *VM) GetFuncPointer(labelA string) int {
+	pointerT, ok := p.Code.Labels[strings.TrimPrefix(labelA, ":")]
+
+	if !ok {
+		return -1
+	}
+
+	if p.UseOpCodes {
+		deepPointerT, ok := p.Code.DeepLabelMap[pointerT]
+
+		if !ok {
+			return -1
+		}
+
+		return deepPointerT
+	}
+
+	return pointerT
+}
+
+// ErrExit is returned by CallFunc if the function runs exit, the exit value is kept as the result of the program
+var ErrExit = errors.New("exit")
+
+// CallFunc calls the function at the code pointer(of the running engine) with the arguments as call does, and waits for its return, returns ErrExit if the function runs exit
+func (p *VM) CallFunc(pointerA int, argsA ...interface{}) (interface{}, error) {
+	savedPointerT := p.CodePointer
+
+	p.nestedCalls++
+
+	defer func() {
+		p.CodePointer = savedPointerT
+		p.nestedCalls--
+	}()
+
+	funcContextT := NewFuncContext()
+
+	funcContextT.Vars[1] = argsA
+
+	if p.UseOpCodes {
+		p.PointerStack.Push(DeepCallStruct{ReturnPointer: -2})
+		p.FuncStack.Push(funcContextT)
+
+		rs := p.runOpCodesFrom(pointerA)
+
+		if nv, ok := rs.(funcReturn); ok {
+			return nv.Value, nil
+		}
+
+		if tk.IsError(rs) {
+			return nil, rs.(error)
+		}
+
+		// the opcodes return the exit value of the program
+		return nil, ErrExit
+	}
+
+	// ret returns ReturnPointer+1, so -1 means the function returns, and the result will be pushed to the stack
+	p.PointerStack.Push(CallStruct{ReturnPointer: -2, ReturnRef: VarRef{-6, nil}})
+	p.FuncStack.Push(funcContextT)
+
+	p.CodePointer = pointerA
+
+	for {
+		if p.CodePointer < 0 || p.CodePointer >= len(p.Code.InstrList) {
+			return nil, fmt.Errorf("function not returned")
+		}
+
+		if errT := p.checkCancel(); errT != nil {
+			return nil, errT
+		}
+
+		if errT := p.checkLimits(); errT != nil {
+			return nil, errT
+		}
+
+		resultT := RunInstr(p, &p.Code.InstrList[p.CodePointer])
+
+		if c1T, ok := resultT.(int); ok {
+			if c1T == -1 {
+				// the result is not pushed if it exceeds the limits
+				if errT := p.checkLimits(); errT != nil {
+					return nil, errT
+				}
+
+				return p.Stack.Pop(), nil
+			}
+
+			p.CodePointer = c1T
+
+			continue
+		}
+
+		if tk.IsError(resultT) {
+			return nil, resultT.(error)
+		}
+
+		rs := tk.ToStr(resultT)
+
+		if rs == "" {
+			p.CodePointer++
+		} else if rs == "exit" {
+			return nil, ErrExit
+		} else {
+			p.CodePointer = tk.StrToInt(rs, -1)
+		}
+	}
+}
+
+// funcPointerOf gets the code pointer(of the running engine) of the label parameter, -1 if not a valid label
+func (p *VM) funcPointerOf(vA interface{}) int {
+	switch nv := vA.(type) {
+	case int:
+		return nv
+	case string:
+		if p.UseOpCodes {
+			return p.GetFuncPointer(nv)
+		}
+
+		return p.GetLabelIndex(nv)
+	}
+
+	return -1
+}
+
+// Range calls the function at the code pointer for each line of the iterator as range does, stops if the function returns false
+func (p *VM) Range(valueA interface{}, pointerA int) error {
+	iteratorT, ok := valueA.(*LineIterator)
+
+	if !ok {
+		return fmt.Errorf("not iterable: %T", valueA)
+	}
+
+	for iteratorT.HasNext() {
+		_, indexT, lineT := iteratorT.Next()
+
+		rs, errT := p.CallFunc(pointerA, indexT, lineT)
+
+		if errT != nil {
+			return errT
+		}
+
+		if nv, ok := rs.(bool); ok && !nv {
+			return nil
+		}
+	}
+
+	return iteratorT.Err()
+}
+
+// CallLabel calls the script function at the label from the host application, the function gets the arguments and returns the value by ret as called by call, it could be called many times on the same VM, or in a Go function called by the running script
+func (p *VM) CallLabel(ctxA context.Context, labelA string, argsA ...interface{}) (interface{}, error) {
+	if ctxA != nil {
+		savedCtxT := p.Ctx
+
+		p.Ctx = ctxA
+
+		defer func() {
+			p.Ctx = savedCtxT
+		}()
+	}
+
+	if !p.running {
+		// the same engine as Run
+		p.UseOpCodes = p.Code.IsDeepCompiled()
+
+		p.instrCount = 0
+		p.budget.reset()
+
+		defer p.startTasks()()
+
+		defer p.Flush()
+	}
+
+	pointerT := p.GetFuncPointer(labelA)
+
+	if pointerT < 0 {
+		return nil, fmt.Errorf("label not found: %v", labelA)
+	}
+
+	funcStackSizeT := p.FuncStack.Size()
+	pointerStackSizeT := p.PointerStack.Size()
+	internalStackSizeT := p.InternalStack.Size()
+
+	rs, errT := p.CallFunc(pointerT, argsA...)
+
+	if errT != nil {
+		// clear the state of the failed function, so the VM could be used again
+		for p.FuncStack.Size() > funcStackSizeT {
+			p.FuncStack.Pop().(*FuncContext).RunDefer(p)
+		}
+
+		for p.PointerStack.Size() > pointerStackSizeT {
+			p.PointerStack.Pop()
+		}
+
+		for p.InternalStack.Size() > internalStackSizeT {
+			p.InternalStack.Pop()
+		}
+
+		return nil, errT
+	}
+
+	return rs, nil
+}
+
+// Go binding related
+
+// Bind binds the Go function or value to the name, so it could be used by callGo in the script, such as vm.Bind("strings.ToUpper", strings.ToUpper)
+func (p *VM) Bind(nameA string, valueA interface{}) {
+	if p.Bindings == nil {
+		p.Bindings = make(map[string]interface{})
+	}
+
+	p.Bindings[nameA] = valueA
+}
+
+// GetBinding gets the bound Go function or value by name
+func (p *VM) GetBinding(nameA string) (interface{}, bool) {
+	v, ok := p.Bindings[nameA]
+
+	return v, ok
+}
+
+var errorTypeG = reflect.TypeOf((*error)(nil)).Elem()
+
+// convertToType converts the script value to the Go type for calling by reflection
+func convertToType(vA interface{}, typeA reflect.Type) (reflect.Value, error) {
+	if vA == nil || tk.IsUndefined(vA) {
+		return reflect.Zero(typeA), nil
+	}
+
+	valueT := reflect.ValueOf(vA)
+
+	if valueT.Type().AssignableTo(typeA) {
+		return valueT, nil
+	}
+
+	switch typeA.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(tk.ToStr(vA)).Convert(typeA), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var nv int64
+
+		switch valueT.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			nv = valueT.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if valueT.Uint() > math.MaxInt64 {
+				return reflect.Value{}, fmt.Errorf("failed to convert %#v to %v: out of range", vA, typeA)
+			}
+
+			nv = int64(valueT.Uint())
+		case reflect.String:
+			c1T, errT := strconv.ParseInt(strings.TrimSpace(vA.(string)), 0, 64)
+
+			if errT != nil {
+				return reflect.Value{}, fmt.Errorf("failed to convert %#v to %v: %v", vA, typeA, errT)
+			}
+
+			nv = c1T
+		default:
+			nv = int64(tk.ToInt(vA, 0))
+		}
+
+		rs := reflect.New(typeA).Elem()
+
+		// the values are not wrapped around as the conversion of Go does
+		if rs.OverflowInt(nv) {
+			return reflect.Value{}, fmt.Errorf("failed to convert %#v to %v: out of range", vA, typeA)
+		}
+
+		rs.SetInt(nv)
+
+		return rs, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var nv uint64
+
+		switch valueT.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			nv = valueT.Uint()
+		case reflect.String:
+			c1T, errT := strconv.ParseUint(strings.TrimSpace(vA.(string)), 0, 64)
+
+			if errT != nil {
+				return reflect.Value{}, fmt.Errorf("failed to convert %#v to %v: %v", vA, typeA, errT)
+			}
+
+			nv = c1T
+		default:
+			// negative values are not wrapped around
+			c1T := int64(tk.ToInt(vA, 0))
+
+			if valueT.Kind() >= reflect.Int && valueT.Kind() <= reflect.Int64 {
+				c1T = valueT.Int()
+			}
+
+			if c1T < 0 {
+				return reflect.Value{}, fmt.Errorf("failed to convert %#v to %v: negative value", vA, typeA)
+			}
+
+			nv = uint64(c1T)
+		}
+
+		rs := reflect.New(typeA).Elem()
+
+		if rs.OverflowUint(nv) {
+			return reflect.Value{}, fmt.Errorf("failed to convert %#v to %v: out of range", vA, typeA)
+		}
+
+		rs.SetUint(nv)
+
+		return rs, nil
+	case reflect.Float32, reflect.Float64:
+		return reflect.ValueOf(tk.ToFloat(vA, 0)).Convert(typeA), nil
+	case reflect.Bool:
+		return reflect.ValueOf(tk.ToBool(vA)).Convert(typeA), nil
+	case reflect.Slice:
+		if valueT.Kind() == reflect.Slice || valueT.Kind() == reflect.Array {
+			lenT := valueT.Len()
+
+			rs := reflect.MakeSlice(typeA, lenT, lenT)
+
+			for i := 0; i < lenT; i++ {
+				itemT, errT := convertToType(valueT.Index(i).Interface(), typeA.Elem())
+
+				if errT != nil {
+					return reflect.Value{}, errT
+				}
+
+				rs.Index(i).Set(itemT)
+			}
+
+			return rs, nil
+		}
+	}
+
+	if valueT.Type().ConvertibleTo(typeA) {
+		return valueT.Convert(typeA), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("failed to convert (%T)%v to %v", vA, vA, typeA)
+}
+
+// CallGoFunc calls the Go function by reflection, the arguments are converted to the parameter types, multiple return values are returned as []interface{}, and a non-nil trailing error is returned as the result value
+func CallGoFunc(funcA interface{}, argsA ...interface{}) (resultR interface{}, errR error) {
+	funcT := reflect.ValueOf(funcA)
+
+	if funcT.Kind() != reflect.Func {
+		return nil, fmt.Errorf("not a function: %T", funcA)
+	}
+
+	typeT := funcT.Type()
+
+	numInT := typeT.NumIn()
+
+	if typeT.IsVariadic() {
+		if len(argsA) < numInT-1 {
+			return nil, fmt.Errorf("not enough arguments: %v/%v", len(argsA), numInT-1)
+		}
+	} else if len(argsA) != numInT {
+		return nil, fmt.Errorf("argument count not match: %v/%v", len(argsA), numInT)
+	}
+
+	inT := make([]reflect.Value, len(argsA))
+
+	for i, v := range argsA {
+		var typeT2 reflect.Type
+
+		if typeT.IsVariadic() && i >= numInT-1 {
+			typeT2 = typeT.In(numInT - 1).Elem()
+		} else {
+			typeT2 = typeT.In(i)
+		}
+
+		argT, errT := convertToType(v, typeT2)
+
+		if errT != nil {
+			return nil, fmt.Errorf("argument %v: %v", i+1, errT)
+		}
+
+		inT[i] = argT
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			errR = fmt.Errorf("failed to call the Go function: %v", r)
+		}
+	}()
+
+	outT := funcT.Call(inT)
+
+	lenT := len(outT)
+
+	if lenT > 0 && typeT.Out(lenT-1) == errorTypeG {
+		if !outT[lenT-1].IsNil() {
+			return outT[lenT-1].Interface(), nil
+		}
+
+		outT = outT[:lenT-1]
+		lenT--
+	}
+
+	if lenT < 1 {
+		return tk.Undefined, nil
+	}
+
+	if lenT == 1 {
+		return outT[0].Interface(), nil
+	}
+
+	listT := make([]interface{}, lenT)
+
+	for i, v := range outT {
+		listT[i] = v.Interface()
+	}
+
+	return listT, nil
+}
+
+// CallGo calls the bound Go function by name(or the function value itself), gets the bound value if it is not a function and no argument is given
+func (p *VM) CallGo(nameA interface{}, argsA ...interface{}) (interface{}, error) {
+	funcT := nameA
+
+	if nv, ok := nameA.(string); ok {
+		v, ok := p.GetBinding(nv)
+
+		if !ok {
+			return nil, fmt.Errorf("Go function not bound: %v", nv)
+		}
+
+		funcT = v
+	}
+
+	if reflect.ValueOf(funcT).Kind() != reflect.Func {
+		if len(argsA) < 1 {
+			return funcT, nil
+		}
+
+		return nil, fmt.Errorf("not a function: %v", nameA)
+	}
+
+	return CallGoFunc(funcT, argsA...)
+}
+
+// CallMethod calls the method of the Go value by reflection, the methods with pointer receivers are also available for non-pointer values
+func CallMethod(objA interface{}, nameA string, argsA ...interface{}) (interface{}, error) {
+	valueT := reflect.ValueOf(objA)
+
+	if !valueT.IsValid() {
+		return nil, fmt.Errorf("nil value for method: %v", nameA)
+	}
+
+	methodT := valueT.MethodByName(nameA)
+
+	if !methodT.IsValid() && valueT.Kind() != reflect.Ptr {
+		ptrT := reflect.New(valueT.Type())
+		ptrT.Elem().Set(valueT)
+
+		methodT = ptrT.MethodByName(nameA)
+	}
+
+	if !methodT.IsValid() {
+		return nil, fmt.Errorf("method not found for %T: %v", objA, nameA)
+	}
+
+	return CallGoFunc(methodT.Interface(), argsA...)
+}
+
+func getStructValue(objA interface{}) (reflect.Value, error) {
+	valueT := reflect.ValueOf(objA)
+
+	for valueT.Kind() == reflect.Ptr || valueT.Kind() == reflect.Interface {
+		if valueT.IsNil() {
+			return reflect.Value{}, fmt.Errorf("nil value: %T", objA)
+		}
+
+		valueT = valueT.Elem()
+	}
+
+	if valueT.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("not a struct: %T", objA)
+	}
+
+	return valueT, nil
+}
+
+// GetField gets the exported field of the struct(or pointer to struct) value by reflection
+func GetField(objA interface{}, nameA string) (interface{}, error) {
+	valueT, errT := getStructValue(objA)
+
+	if errT != nil {
+		return nil, errT
+	}
+
+	fieldT := valueT.FieldByName(nameA)
+
+	if !fieldT.IsValid() {
+		return nil, fmt.Errorf("field not found for %T: %v", objA, nameA)
+	}
+
+	if !fieldT.CanInterface() {
+		return nil, fmt.Errorf("unexported field of %T: %v", objA, nameA)
+	}
+
+	return fieldT.Interface(), nil
+}
+
+// SetField sets the exported field of the struct value by reflection, returns the same pointer for a pointer to struct, or the modified copy for a struct
+func SetField(objA interface{}, nameA string, valueA interface{}) (interface{}, error) {
+	resultT := objA
+
+	valueT, errT := getStructValue(objA)
+
+	if errT != nil {
+		return nil, errT
+	}
+
+	if !valueT.CanSet() {
+		copyT := reflect.New(valueT.Type()).Elem()
+		copyT.Set(valueT)
+
+		valueT = copyT
+		resultT = nil
+	}
+
+	fieldT := valueT.FieldByName(nameA)
+
+	if !fieldT.IsValid() {
+		return nil, fmt.Errorf("field not found for %T: %v", objA, nameA)
+	}
+
+	if !fieldT.CanSet() {
+		return nil, fmt.Errorf("unexported field of %T: %v", objA, nameA)
+	}
+
+	newValueT, errT := convertToType(valueA, fieldT.Type())
+
+	if errT != nil {
+		return nil, errT
+	}
+
+	fieldT.Set(newValueT)
+
+	if resultT == nil {
+		return valueT.Interface(), nil
+	}
+
+	return resultT, nil
+}
+
+// EvalReflectInstr runs the method/getField/setField instructions with the resolved parameters(without the result one)
+func EvalReflectInstr(codeA int, argsA []interface{}) (interface{}, error) {
+	objT := argAt(argsA, 0, nil)
+	nameT := tk.ToStr(argAt(argsA, 1, ""))
+
+	switch codeA {
+	case 22111: // method
+		return CallMethod(objT, nameT, argsA[2:]...)
+	case 22113: // getField
+		return GetField(objT, nameT)
+	case 22115: // setField
+		return SetField(objT, nameT, argAt(argsA, 2, nil))
+	}
+
+	return nil, fmt.Errorf("unknown reflect instr: %v", codeA)
+}
+
+var reflectInstrInfoMapG = map[int]instrOpInfo{
+	22111: {OpMethod, 3},
+	22113: {OpGetField, 3},
+	22115: {OpSetField, 4},
+}
+
+// goroutine related
+
+// Task is the handle of the function running in a goroutine, started by the go instruction
+type Task struct {
+	VM *VM
+
+	done chan struct{}
+
+	result interface{}
+	err    error
+}
+
+// Wait waits for the task to finish and gets the return value of the function, could be interrupted by the context(returns *CancelError)
+func (p *Task) Wait(ctxA context.Context) (interface{}, error) {
+	select {
+	case <-p.done:
+		return p.result, p.err
+	case <-ctxA.Done():
+		return nil, &CancelError{Err: ctxA.Err()}
+	}
+}
+
+// NewChildVM creates a VM sharing the code, the global variables, the bindings, the input/output and the settings with the VM, but with its own stacks and local variables
+func (p *VM) NewChildVM() *VM {
+	childT := &VM{}
+
+	childT.Code = p.Code
+
+	childT.Ctx = p.Ctx
+
+	// the tasks are stopped when the run ends
+	if p.tasksCtx != nil {
+		childT.Ctx = p.tasksCtx
+	}
+
+	childT.Stdin = p.Stdin
+	childT.Stdout = p.Stdout
+	childT.Stderr = p.Stderr
+
+	childT.Permissions = p.Permissions
+	childT.Limits = p.Limits
+	childT.MaxInstrs = p.MaxInstrs
+	childT.Debug = p.Debug
+	childT.Bindings = p.Bindings
+	childT.ArgsOffset = p.ArgsOffset
+
+	childT.UseOpCodes = p.UseOpCodes
+
+	childT.outputLock = p.outputLock
+	childT.stdin = p.stdin
+	childT.regexLRU = p.regexLRU
+
+	childT.initState()
+
+	childT.budget = p.budget
+
+	childT.Regs[0] = p.Regs[0]
+	childT.globalsLock = p.globalsLock
+
+	childT.Regs[1] = p.Regs[1]
+
+	return childT
+}
+
+// startTasks derives the context for the tasks started by go in the run, the returned function should be called when the run ends, it cancels the tasks still running and waits until they end(the tasks blocked in the Go functions called by callGo are waited too)
+func (p *VM) startTasks() func() {
+	ctxT, cancelT := context.WithCancel(p.Ctx)
+
+	p.tasksCtx = ctxT
+
+	return func() {
+		cancelT()
+
+		p.budget.tasksWg.Wait()
+
+		p.tasksCtx = nil
+	}
+}
+
+// Go calls the function at the code pointer(of the running engine) with the arguments in a child VM in a new goroutine
+func (p *VM) Go(pointerA int, argsA ...interface{}) *Task {
+	childT := p.NewChildVM()
+
+	taskT := &Task{VM: childT, done: make(chan struct{})}
+
+	p.budget.tasks.Add(1)
+	p.budget.tasksWg.Add(1)
+
+	go func() {
+		defer p.budget.tasksWg.Done()
+
+		defer close(taskT.done)
+
+		defer p.budget.tasks.Add(-1)
+
+		defer func() {
+			if r := recover(); r != nil {
+				taskT.result = nil
+				taskT.err = fmt.Errorf("runtime exception: %v\n%v", r, string(debug.Stack()))
+			}
+		}()
+
+		defer childT.Flush()
+
+		childT.running = true
+
+		taskT.result, taskT.err = childT.CallFunc(pointerA, argsA...)
+
+		if taskT.err != nil {
+			childT.RunDeferUpToRoot()
+		}
+	}()
+
+	return taskT
+}
+
+// EvalGoInstr runs the go/wait instructions with the resolved parameters(without the result one), the error of the function waited is returned as the result value
+func (p *VM) EvalGoInstr(codeA int, argsA []interface{}) (interface{}, error) {
+	switch codeA {
+	case 20641: // go
+		pointerT := p.funcPointerOf(argsA[0])
+
+		if pointerT < 0 {
+			return nil, fmt.Errorf("invalid label: %v", argsA[0])
+		}
+
+		return p.Go(pointerT, argsA[1:]...), nil
+	case 20643: // wait
+		taskT, ok := argsA[0].(*Task)
+
+		if !ok {
+			return nil, fmt.Errorf("not a task: %T", argsA[0])
+		}
+
+		rs, errT := taskT.Wait(p.Ctx)
+
+		if errT != nil {
+			if _, ok := errT.(*CancelError); ok {
+				return nil, errT
+			}
+
+			return errT, nil
+		}
+
+		return rs, nil
+	}
+
+	return nil, fmt.Errorf("unknown goroutine instr: %v", codeA)
+}
+
+// pipe related
+
+// SplitCmdLine splits the command line to the command and arguments, the quotes will be removed
+func SplitCmdLine(strA string) ([]string, error) {
+	listT, errT := ParseLine(strA)
+
+	if errT != nil {
+		return nil, errT
+	}
+
+	for i, v := range listT {
+		if len(v) < 2 {
+			continue
+		}
+
+		if strings.HasPrefix(v, `"`) && strings.HasSuffix(v, `"`) {
+			tmps, errT := strconv.Unquote(v)
+
+			if errT == nil {
+				listT[i] = tmps
+			}
+		} else if (strings.HasPrefix(v, "'") && strings.HasSuffix(v, "'")) || (strings.HasPrefix(v, "`") && strings.HasSuffix(v, "`")) {
+			listT[i] = v[1 : len(v)-1]
+		}
+	}
+
+	return listT, nil
+}
+
+// stageLabel is the label(with the leading colon) of a script function stage in pipe
+type stageLabel string
+
+// Pipe runs the stages in a pipeline, argsA: the stages and the options, returns ErrExit if a script function stage runs exit
+func (p *VM) Pipe(argsA []interface{}) interface{} {
+	optsT := &CmdOptions{}
+
+	stagesT := make([]interface{}, 0, len(argsA))
+
+	for _, v := range argsA {
+		if nv, ok := v.(string); ok {
+			if strings.HasPrefix(nv, "-cwd=") {
+				optsT.Dir = nv[5:]
+				continue
+			} else if strings.HasPrefix(nv, "-env=") {
+				optsT.Env = append(optsT.Env, nv[5:])
+				continue
+			} else if strings.HasPrefix(nv, "-stdin=") {
+				optsT.Stdin = nv[7:]
+				continue
+			}
+		}
+
+		stagesT = append(stagesT, v)
+	}
+
+	if len(stagesT) < 1 {
+		return fmt.Errorf("no stages in the pipe")
+	}
+
+	p.Flush()
+
+	exitCodesT := make([]int, len(stagesT))
+	stderrsT := make([]*bytes.Buffer, len(stagesT))
+
+	var inputT io.Reader = strings.NewReader("")
+
+	if optsT.Stdin != nil {
+		if nv, ok := optsT.Stdin.([]byte); ok {
+			inputT = bytes.NewReader(nv)
+		} else {
+			inputT = strings.NewReader(tk.ToStr(optsT.Stdin))
+		}
+	}
+
+	var wgT sync.WaitGroup
+
+	// the running commands will be killed if the pipe failed
+	ctxT, cancelT := context.WithCancel(p.Ctx)
+	defer cancelT()
+
+	closeInputT := func() {
+		if nv, ok := inputT.(*io.PipeReader); ok {
+			nv.CloseWithError(io.ErrClosedPipe)
+		}
+	}
+
+	defer closeInputT()
+
+	// stop the running stages and wait for them before returning the error
+	failT := func(errA error) interface{} {
+		cancelT()
+		closeInputT()
+
+		wgT.Wait()
+
+		return errA
+	}
+
+	for i, v := range stagesT {
+		stderrsT[i] = new(bytes.Buffer)
+
+		pointerT := -1
+
+		if nv, ok := v.(stageLabel); ok {
+			pointerT = p.GetFuncPointer(string(nv))
+
+			if pointerT < 0 {
+				return failT(fmt.Errorf("label not found: %v", nv))
+			}
+		}
+
+		if pointerT >= 0 { // script function
+			// the function reads the lines of the input while the previous stages are still running
+			lineReaderT := bufio.NewReader(inputT)
+
+			iteratorT := NewLineIterator(func() (string, error) {
+				return readLineFrom(lineReaderT)
+			})
+
+			rs, errT := p.CallFunc(pointerT, iteratorT)
+
+			if errT == nil {
+				errT = iteratorT.Err()
+			}
+
+			if errors.Is(errT, ErrExit) {
+				return failT(ErrExit)
+			}
+
+			if errT != nil {
+				return failT(fmt.Errorf("failed to run stage %v: %v", i+1, errT))
+			}
+
+			// the previous stage should stop writing if the function does not read all the input
+			closeInputT()
+
+			if tk.IsError(rs) {
+				exitCodesT[i] = 1
+				stderrsT[i].WriteString(tk.GetErrStrX(rs))
+				rs = ""
+			}
+
+			if nv, ok := rs.([]byte); ok {
+				inputT = bytes.NewReader(nv)
+			} else {
+				inputT = strings.NewReader(tk.ToStr(rs))
+			}
+
+			continue
+		}
+
+		var cmdArgsT []string
+
+		if nv, ok := v.(string); ok {
+			var errT error
+
+			cmdArgsT, errT = SplitCmdLine(nv)
+
+			if errT != nil {
+				return failT(fmt.Errorf("invalid command line of stage %v: %v", i+1, errT))
+			}
+		} else {
+			cmdArgsT = toStrList(v)
+		}
+
+		if len(cmdArgsT) < 1 {
+			return failT(fmt.Errorf("command not specified in stage %v", i+1))
+		}
+
+		cmdT := newCmd(ctxT, cmdArgsT, &CmdOptions{Dir: optsT.Dir, Env: optsT.Env})
+
+		cmdT.Stdin = inputT
+		cmdT.Stderr = stderrsT[i]
+
+		readerT, writerT := io.Pipe()
+
+		cmdT.Stdout = writerT
+
+		errT := cmdT.Start()
+
+		if errT != nil {
+			writerT.Close()
+			return failT(fmt.Errorf("failed to start stage %v: %v", i+1, errT))
+		}
+
+		wgT.Add(1)
+
+		go func(idxA int, cmdA *exec.Cmd, stdinA io.Reader) {
+			defer wgT.Done()
+
+			errT := cmdA.Wait()
+
+			if errT != nil {
+				exitCodesT[idxA] = -1
+
+				if exitErrT, ok := errT.(*exec.ExitError); ok {
+					exitCodesT[idxA] = exitErrT.ExitCode()
+				}
+			}
+
+			writerT.Close()
+
+			// let the previous stage stop writing if this stage exits early
+			if nv, ok := stdinA.(*io.PipeReader); ok {
+				nv.CloseWithError(io.ErrClosedPipe)
+			}
+		}(i, cmdT, inputT)
+
+		inputT = readerT
+	}
+
+	var outputReaderT io.Reader = inputT
+
+	// read at most 1 byte more than the limit to check it before building the whole string
+	if p.Limits.MaxStrLen > 0 {
+		outputReaderT = io.LimitReader(inputT, int64(p.Limits.MaxStrLen)+1)
+	}
+
+	outputT, errT := io.ReadAll(outputReaderT)
+
+	if p.Limits.MaxStrLen > 0 && len(outputT) > p.Limits.MaxStrLen {
+		// stop the stages still writing
+		cancelT()
+		closeInputT()
+	}
+
+	wgT.Wait()
+
+	if errT != nil {
+		return fmt.Errorf("failed to read the output: %v", errT)
+	}
+
+	if p.Limits.MaxStrLen > 0 && len(outputT) > p.Limits.MaxStrLen {
+		return p.setLimitErr("MaxStrLen", len(outputT), p.Limits.MaxStrLen)
+	}
+
+	stderrListT := make([]string, len(stderrsT))
+
+	for i, v := range stderrsT {
+		stderrListT[i] = v.String()
+	}
+
+	return map[string]interface{}{"output": string(outputT), "exitCodes": exitCodesT, "stderr": stderrListT}
+}
+
+func RunInstr(p *VM, instrA *Instr) (resultR interface{}) {
+	// startT := time.Now()
+
+	defer func() {
+		// endT := time.Now()
+
+		// tk.Pl("instr(%#v) dur: %d", instrA.Code, endT.Sub(startT))
+
+		if r1 := recover(); r1 != nil {
+			// tk.Printfln("exception: %v", r)
+			// if p.ErrorHandler > -1 {
+			// 	p.SetVarGlobal("lastLineG", r.CodeSourceMap[r.CodePointer]+1)
+			// 	p.SetVarGlobal("errorMessageG", tk.ToStr(r))
+			// 	p.SetVarGlobal("errorDetailG", fmt.Errorf("runtime error: %v\n%v", r, string(debug.Stack())))
+
+			// 	// p.Stack.Push(fmt.Errorf("runtime error: %v\n%v", r, string(debug.Stack())))
+			// 	// p.Stack.Push(tk.ToStr(r))
+			// 	// p.Stack.Push(r.CodeSourceMap[r.CodePointer] + 1)
+			// 	resultR = r.ErrorHandler
+			// 	return
+			// }
+
+			resultR = fmt.Errorf("runtime exception: %v\n%v", r1, string(debug.Stack()))
+
+			return
+		}
+	}()
+
+	var instrT *Instr = instrA
+
+	// if p.VerbosePlusM {
+	// tk.Plv(instrT)
+	// }
+
+	if instrT == nil {
+		return fmt.Errorf("nil instr: %v", instrT)
+	}
+
+	cmdT := instrT.Code
+
+	if toResultT, errT := p.CheckPermission(instrT); errT != nil {
+		if toResultT {
+			p.SetVar(instrT.Params[0], errT)
+
+			return ""
+		}
+
+		return errT
+	}
+
+	switch cmdT {
+	case 12: // invalidInstr
+		return fmt.Errorf("invalid instr: %v", instrT.Params[0].Value)
+	case 100: // version
+		pr := instrT.Params[0]
+
+		p.SetVar(pr, VersionG)
+
+		return ""
+	case 101: // pass
+		return ""
+	case 122: // testByText
+		if instrT.ParamLen < 2 {
+			return p.Errf("not enough parameters(参数不够)")
+		}
+
+		errT := p.TestByText(p.ParamsToList(instrT, 0))
+
+		if errT != nil {
+			return p.Errf("%v", errT)
+		}
+
+		return ""
+
+	case 151: // isErr
+		if instrT.ParamLen < 2 {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		p.SetVar(pr, isErrValue(p.GetVarValue(instrT.Params[1])))
+
+		return ""
+
+	case 152: // getErrStr
+		if instrT.ParamLen < 2 {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		p.SetVar(pr, tk.GetErrStrX(p.GetVarValue(instrT.Params[1])))
+
+		return ""
+
+	case 180: // goto
+		if instrT.ParamLen < 1 {
+			return p.Errf("not enough parameters")
+		}
+
+		v1 := p.GetVarValue(instrT.Params[0]).(int)
+
+		// c1 := p.GetLabelIndex(v1)
+
+		if v1 >= 0 {
+			return v1
+		}
+
+		return p.Errf("invalid label: %v", v1)
+
+	case 198: // exitCode
+		if instrT.ParamLen < 1 {
+			return p.Errf("not enough parameters")
+		}
+
+		p.Regs[2] = tk.ToInt(p.GetVarValue(instrT.Params[0]), 1)
+
+		return ""
+
+	case 199: // exit
+		if instrT.ParamLen < 1 {
+			return "exit"
+		}
+
+		valueT := p.GetVarValue(instrT.Params[0])
+
+		p.Regs[2] = valueT
+
+		return "exit"
+
+	case 220: // push
+		if instrT.ParamLen < 1 {
+			return p.Errf("not enough parameters")
+		}
+
+		v1 := p.GetVarValue(instrT.Params[0])
+
+		p.Stack.Push(v1)
+
+		return ""
+	case 222: // peek
+		if instrT.ParamLen < 1 {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		errT := p.SetVar(pr, p.Stack.Peek())
+
+		if errT != nil {
+			return p.Errf("%v", errT)
+		}
+
+		return ""
+
+	case 224: // pop
+		pr := instrT.Params[0]
+
+		p.SetVar(pr, p.Stack.Pop())
+
+		return ""
+	case 401: // assign/=
+		if instrT.ParamLen < 2 {
+			return fmt.Errorf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		var valueT interface{}
+
+		valueT = p.GetVarValue(instrT.Params[1])
+
+		p.SetVar(pr, valueT)
+
+		return ""
+
+	case 610: // if
+		// tk.Plv(instrT)
+		if instrT.ParamLen < 2 {
+			return p.Errf("not enough parameters")
+		}
+
+		var condT bool
+		var v2 interface{}
+		var v2o interface{}
+		var ok0 bool
+
+		var elseLabelIntT int = -1
+
+		if instrT.ParamLen > 2 {
+			elseLabelT := p.GetLabelIndex(p.GetVarValue(instrT.Params[2]))
+
+			if elseLabelT < 0 {
+				return p.Errf("invalid label: %v", elseLabelT)
+			}
+
+			elseLabelIntT = elseLabelT
+		}
+
+		v2o = instrT.Params[1]
+
+		v2 = p.GetVarValue(instrT.Params[1])
+
+		// tk.Plv(instrT)
+		tmpv := p.GetVarValue(instrT.Params[0])
+		p.plDebug("if %v -> %v", instrT.Params[0], tmpv)
 
 		condT, ok0 = tmpv.(bool)
 
@@ -1870,39 +5989,310 @@ func RunInstr(p *VM, instrA *Instr) (resultR interface{}) {
 				}
 			}
 
-			p.SetVar(pr, nv[v2])
-		default:
-			valueT := reflect.ValueOf(v1)
+			p.SetVar(pr, nv[v2])
+		default:
+			valueT := reflect.ValueOf(v1)
+
+			kindT := valueT.Kind()
+
+			if kindT == reflect.Array || kindT == reflect.Slice || kindT == reflect.String {
+				lenT := valueT.Len()
+
+				if (v2 < 0) || (v2 >= lenT) {
+					return p.Errf("index out of range: %v/%v", v2, lenT)
+				}
+
+				p.SetVar(pr, valueT.Index(v2).Interface())
+				return ""
+			}
+
+			if instrT.ParamLen > 3 {
+				p.SetVar(pr, p.GetVarValue(instrT.Params[3]))
+			} else {
+				p.SetVar(pr, tk.Undefined)
+			}
+
+			return p.Errf("parameter types not match: %#v", v1)
+		}
+
+		return ""
+
+	case 1501, 1503, 1511, 1521, 1522, 1531, 1533, 1535, 1537, 1541, 1551, 1553, 1555, 1556, 1571, 1573, 1575: // string related
+		if instrT.ParamLen < strInstrInfoMapG[cmdT].ParamLen {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		argsT := p.ParamsToList(instrT, 1)
+
+		if errT := p.checkStrInstrArgs(cmdT, argsT); errT != nil {
+			return errT
+		}
+
+		rs := EvalStrInstr(cmdT, argsT)
+
+		if tk.IsError(rs) {
+			return p.Errf("%v", rs)
+		}
+
+		p.SetVar(pr, rs)
+
+		return ""
+
+	case 1611, 1613, 1615, 1617, 1621, 1631: // regex related
+		if instrT.ParamLen < regInstrInfoMapG[cmdT].ParamLen {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		argsT := p.ParamsToList(instrT, 1)
+
+		if errT := p.checkRegInstrArgs(cmdT, argsT); errT != nil {
+			return errT
+		}
+
+		rs := p.EvalRegInstr(cmdT, argsT)
+
+		if tk.IsError(rs) {
+			return p.Errf("%v", rs)
+		}
+
+		p.SetVar(pr, rs)
+
+		return ""
+
+	case 21101, 21103, 21105, 21111, 21113, 21201, 21203, 21205, 21211, 21301, 21303, 21305, 21311: // file related
+		if instrT.ParamLen < fileInstrInfoMapG[cmdT].ParamLen {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		p.SetVar(pr, p.EvalFileInstr(cmdT, p.ParamsToList(instrT, 1)))
+
+		return ""
+
+	case 21901, 21903, 21905, 21907, 21911, 21913, 21921, 21922, 21925, 21927: // path related
+		if instrT.ParamLen < pathInstrInfoMapG[cmdT].ParamLen {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		p.SetVar(pr, EvalPathInstr(cmdT, p.ParamsToList(instrT, 1)))
+
+		return ""
+
+	case 1910: // now
+
+		pr := instrT.Params[0]
+
+		errT := p.SetVar(pr, time.Now())
+
+		if errT != nil {
+			return p.Errf("%v", errT)
+		}
+
+		return ""
+
+	case 10410: // pln
+		list1T := []interface{}{}
+
+		for _, v := range instrT.Params {
+			list1T = append(list1T, p.GetVarValue(v))
+		}
+
+		p.Pln(list1T...)
+
+		return ""
+
+	case 10411: // plo
+		vs := p.ParamsToList(instrT, 0)
+
+		p.Plo(vs...)
+
+		return ""
+
+	case 10401: // pr
+		p.Print(sprintfArgs(p.ParamsToList(instrT, 0)))
+
+		return ""
+
+	case 10420: // pl
+		p.Pln(sprintfArgs(p.ParamsToList(instrT, 0)))
+
+		return ""
+
+	case 10430: // plErr
+		p.PlErr(sprintfArgs(p.ParamsToList(instrT, 0)))
+
+		return ""
+
+	case 10501, 10503, 10505, 10511: // input related
+		if instrT.ParamLen < inputInstrInfoMapG[cmdT].ParamLen {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		rs := p.EvalInputInstr(cmdT, p.ParamsToList(instrT, 1))
+
+		if nv, ok := rs.(*CancelError); ok {
+			return nv
+		}
+
+		p.SetVar(pr, rs)
+
+		return ""
+
+	case 10451: // spr
+		if instrT.ParamLen < 2 {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		argsT := p.ParamsToList(instrT, 1)
+
+		if errT := p.checkStrInstrArgs(cmdT, argsT); errT != nil {
+			return errT
+		}
+
+		p.SetVar(pr, sprintfArgs(argsT))
+
+		return ""
+
+	case 20501: // sleep
+		if instrT.ParamLen < 1 {
+			return p.Errf("not enough parameters")
+		}
+
+		errT := p.Sleep(tk.ToFloat(p.GetVarValue(instrT.Params[0]), 0))
+
+		if errT != nil {
+			return errT
+		}
+
+		return ""
+
+	case 20611, 20613, 20615, 20617, 20619: // process related
+		if instrT.ParamLen < processInstrInfoMapG[cmdT].ParamLen {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		if cmdT == 20611 {
+			p.SetVar(pr, p.StartProcess(p.ParamsToList(instrT, 1)))
+		} else {
+			p.SetVar(pr, p.EvalProcessInstr(cmdT, p.ParamsToList(instrT, 1)))
+		}
+
+		return ""
+
+	case 1070: // range
+		if instrT.ParamLen < 2 {
+			return p.Errf("not enough parameters")
+		}
+
+		pointerT := p.funcPointerOf(p.GetVarValue(instrT.Params[1]))
+
+		if pointerT < 0 {
+			return p.Errf("invalid label: %v", instrT.Params[1].Value)
+		}
+
+		errT := p.Range(p.GetVarValue(instrT.Params[0]), pointerT)
+
+		if errT != nil {
+			if _, ok := errT.(*CancelError); ok {
+				return errT
+			}
+
+			return p.Errf("%v", errT)
+		}
+
+		return ""
+
+	case 20641, 20643: // go, wait
+		if instrT.ParamLen < 2 {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		rs, errT := p.EvalGoInstr(cmdT, p.ParamsToList(instrT, 1))
+
+		if errT != nil {
+			if _, ok := errT.(*CancelError); ok {
+				return errT
+			}
+
+			return p.Errf("%v", errT)
+		}
+
+		p.SetVar(pr, rs)
+
+		return ""
+
+	case 20631: // pipe
+		if instrT.ParamLen < 2 {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
 
-			kindT := valueT.Kind()
+		rs := p.Pipe(p.ParamsToList(instrT, 1))
 
-			if kindT == reflect.Array || kindT == reflect.Slice || kindT == reflect.String {
-				lenT := valueT.Len()
+		if rs == ErrExit {
+			return "exit"
+		}
 
-				if (v2 < 0) || (v2 >= lenT) {
-					return p.Errf("index out of range: %v/%v", v2, lenT)
-				}
+		p.SetVar(pr, rs)
 
-				p.SetVar(pr, valueT.Index(v2).Interface())
-				return ""
-			}
+		return ""
 
-			if instrT.ParamLen > 3 {
-				p.SetVar(pr, p.GetVarValue(instrT.Params[3]))
-			} else {
-				p.SetVar(pr, tk.Undefined)
-			}
+	case 20603: // systemCmdEx
+		if instrT.ParamLen < 2 {
+			return p.Errf("not enough parameters")
+		}
 
-			return p.Errf("parameter types not match: %#v", v1)
+		pr := instrT.Params[0]
+
+		rs := p.SystemCmdEx(p.ParamsToList(instrT, 1))
+
+		if nv, ok := rs.(*CancelError); ok {
+			return nv
 		}
 
+		p.SetVar(pr, rs)
+
 		return ""
 
-	case 1910: // now
+	case 20301, 20303, 20305, 20311: // command-line related
+		if instrT.ParamLen < argsInstrInfoMapG[cmdT].ParamLen {
+			return p.Errf("not enough parameters")
+		}
 
 		pr := instrT.Params[0]
 
-		errT := p.SetVar(pr, time.Now())
+		rs := p.EvalArgsInstr(cmdT, p.ParamsToList(instrT, 1))
+
+		if tk.IsError(rs) {
+			return p.Errf("%v", rs)
+		}
+
+		p.SetVar(pr, rs)
+
+		return ""
+
+	case 20505: // trap
+		if instrT.ParamLen < 2 {
+			return p.Errf("not enough parameters")
+		}
+
+		errT := p.Trap(tk.ToStr(p.GetVarValue(instrT.Params[0])), p.GetLabelIndex(p.GetVarValue(instrT.Params[1])))
 
 		if errT != nil {
 			return p.Errf("%v", errT)
@@ -1910,21 +6300,50 @@ func RunInstr(p *VM, instrA *Instr) (resultR interface{}) {
 
 		return ""
 
-	case 10410: // pln
-		list1T := []interface{}{}
+	case 20521, 20522, 20523, 20525, 20527: // env related
+		if instrT.ParamLen < envInstrInfoMapG[cmdT].ParamLen {
+			return p.Errf("not enough parameters")
+		}
 
-		for _, v := range instrT.Params {
-			list1T = append(list1T, p.GetVarValue(v))
+		pr := instrT.Params[0]
+
+		p.SetVar(pr, EvalEnvInstr(cmdT, p.ParamsToList(instrT, 1)))
+
+		return ""
+
+	case 22111, 22113, 22115: // method, getField, setField
+		if instrT.ParamLen < reflectInstrInfoMapG[cmdT].ParamLen {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		rs, errT := EvalReflectInstr(cmdT, p.ParamsToList(instrT, 1))
+
+		if errT != nil {
+			return p.Errf("%v", errT)
 		}
 
-		fmt.Println(list1T...)
+		p.SetVar(pr, rs)
 
 		return ""
 
-	case 10411: // plo
-		vs := p.ParamsToList(instrT, 0)
+	case 22101: // callGo
+		if instrT.ParamLen < 2 {
+			return p.Errf("not enough parameters")
+		}
+
+		pr := instrT.Params[0]
+
+		argsT := p.ParamsToList(instrT, 1)
+
+		rs, errT := p.CallGo(argsT[0], argsT[1:]...)
+
+		if errT != nil {
+			return p.Errf("%v", errT)
+		}
 
-		tk.Plo(vs...)
+		p.SetVar(pr, rs)
 
 		return ""
 
@@ -1942,7 +6361,13 @@ func RunInstr(p *VM, instrA *Instr) (resultR interface{}) {
 
 		// tk.Pln(v1, ",", optsA)
 
-		p.SetVar(pr, tk.SystemCmd(v1, optsA...))
+		rs := p.SystemCmd(v1, optsA...)
+
+		if nv, ok := rs.(*CancelError); ok {
+			return nv
+		}
+
+		p.SetVar(pr, rs)
 
 		return ""
 
@@ -2005,39 +6430,356 @@ func RunInstr(p *VM, instrA *Instr) (resultR interface{}) {
 
 	}
 
+	if hostInstrT := p.Code.getHostInstr(cmdT); hostInstrT != nil {
+		if instrT.ParamLen < hostInstrT.ParamLen() {
+			return p.Errf("not enough parameters")
+		}
+
+		if hostInstrT.Spec.NoResult {
+			_, errT := hostInstrT.Handler(p, p.ParamsToList(instrT, 0))
+
+			if errT != nil {
+				return p.Errf("%v", errT)
+			}
+
+			return ""
+		}
+
+		pr := instrT.Params[0]
+
+		rs, errT := hostInstrT.Handler(p, p.ParamsToList(instrT, 1))
+
+		if errT != nil {
+			return p.Errf("%v", errT)
+		}
+
+		p.SetVar(pr, rs)
+
+		return ""
+	}
+
 	return fmt.Errorf("unknown instr: %v", instrT)
 }
 
-func (p *FuncContext) RunDefer(vmA *VM) error {
-	for {
-		instrT := p.DeferStack.Pop()
-
-		// tk.Pl("\nDeferStack.Pop: %#v\n", instrT)
+func (p *FuncContext) RunDefer(vmA *VM) error {
+	for {
+		instrT := p.DeferStack.Pop()
+
+		// tk.Pl("\nDeferStack.Pop: %#v\n", instrT)
+
+		if instrT == nil || tk.IsUndefined(instrT) {
+			break
+		}
+
+		nv, ok := instrT.(*Instr)
+
+		if !ok {
+			nvv, ok := instrT.(Instr)
+
+			if ok {
+				nv = &nvv
+			} else {
+				return fmt.Errorf("invalid instruction: %#v", instrT)
+			}
+		}
+
+		// if GlobalsG.VerboseLevel > 1 {
+		// 	tk.Pl("defer run: %v", nv)
+		// }
+
+		rs := RunInstr(vmA, nv)
+
+		if tk.IsError(rs) {
+			return fmt.Errorf("[%v](xie) runtime error: %v", tk.GetNowTimeStringFormal(), tk.GetErrStrX(rs))
+		}
+	}
+
+	return nil
+}
+
+// Limits is the resource limits of the VM, 0 means no limit
+type Limits struct {
+	MaxStackDepth     int // the maximum item count of the data stack(push/pop) and the internal stack
+	MaxCallDepth      int // the maximum depth of the nested function calls
+	MaxStrLen         int // the maximum length(in bytes) of the string(or bytes) values
+	MaxCollectionSize int // the maximum item count of the list/map values
+	MaxOutputBytes    int // the maximum total bytes printed to Stdout and Stderr, the output is truncated at the limit
+}
+
+// LimitError is the runtime error while a resource limit of the VM is exceeded
+type LimitError struct {
+	Limit string // the name of the field in Limits, such as MaxStrLen
+	Value int
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("limit exceeded: %v(%v > %v)", e.Limit, e.Value, e.Max)
+}
+
+func (p *VM) setLimitErr(limitA string, valueA int, maxA int) error {
+	errT := &LimitError{Limit: limitA, Value: valueA, Max: maxA}
+
+	p.budget.limitErr.CompareAndSwap(nil, errT)
+
+	return errT
+}
+
+// vmBudget is the usage of the instruction budget and the limits, shared by the VM and its child VMs started by go
+type vmBudget struct {
+	instrCount  atomic.Int64
+	outputBytes atomic.Int64
+
+	// the first limit exceeded
+	limitErr atomic.Pointer[LimitError]
+
+	// the count of the running tasks started by go
+	tasks atomic.Int64
+
+	// the running tasks started by go, to wait for them when the run ends
+	tasksWg sync.WaitGroup
+}
+
+// reset clears the usage before running, the running tasks are still counted
+func (p *vmBudget) reset() {
+	p.instrCount.Store(0)
+	p.outputBytes.Store(0)
+	p.limitErr.Store(nil)
+}
+
+// checkValue checks the size of the value to be assigned, the error will be raised by checkLimits after the instruction
+func (p *VM) checkValue(vA interface{}) error {
+	if p.Limits.MaxStrLen < 1 && p.Limits.MaxCollectionSize < 1 {
+		return nil
+	}
+
+	switch nv := vA.(type) {
+	case string:
+		if p.Limits.MaxStrLen > 0 && len(nv) > p.Limits.MaxStrLen {
+			return p.setLimitErr("MaxStrLen", len(nv), p.Limits.MaxStrLen)
+		}
+
+		return nil
+	case []byte:
+		if p.Limits.MaxStrLen > 0 && len(nv) > p.Limits.MaxStrLen {
+			return p.setLimitErr("MaxStrLen", len(nv), p.Limits.MaxStrLen)
+		}
+
+		return nil
+	case nil:
+		return nil
+	}
+
+	if p.Limits.MaxCollectionSize > 0 {
+		valueT := reflect.ValueOf(vA)
+
+		switch valueT.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			if valueT.Len() > p.Limits.MaxCollectionSize {
+				return p.setLimitErr("MaxCollectionSize", valueT.Len(), p.Limits.MaxCollectionSize)
+			}
+		}
+	}
+
+	return nil
+}
+
+// strLenOf gets the length of the value converted to string
+func strLenOf(vA interface{}) int {
+	switch nv := vA.(type) {
+	case string:
+		return len(nv)
+	case []byte:
+		return len(nv)
+	}
+
+	return len(tk.ToStr(vA))
+}
+
+// checkStrInstrArgs checks the length of the string to be built by strAdd/join/spr/replace/repeat/padLeft/padRight before building it, the length of spr is estimated without the width of the verbs
+func (p *VM) checkStrInstrArgs(codeA int, argsA []interface{}) error {
+	if p.Limits.MaxStrLen < 1 {
+		return nil
+	}
+
+	lenT := 0
+
+	switch codeA {
+	case 1501, 10451: // strAdd, spr
+		for _, v := range argsA {
+			lenT += strLenOf(v)
+		}
+	case 1575: // join
+		sepLenT := len(tk.ToStr(argAt(argsA, 1, "")))
+
+		switch nv := argAt(argsA, 0, nil).(type) {
+		case []string:
+			for _, v := range nv {
+				lenT += len(v) + sepLenT
+			}
+		case []interface{}:
+			for _, v := range nv {
+				lenT += strLenOf(v) + sepLenT
+			}
+		}
+	case 1541: // replace
+		s1 := tk.ToStr(argAt(argsA, 0, ""))
+		oldT := tk.ToStr(argAt(argsA, 1, ""))
+		countT := tk.ToInt(argAt(argsA, 3, -1), -1)
+
+		// an empty old string matches at the beginning and after each rune
+		matchesT := utf8.RuneCountInString(s1) + 1
+
+		if oldT != "" {
+			matchesT = strings.Count(s1, oldT)
+		}
+
+		if countT >= 0 && countT < matchesT {
+			matchesT = countT
+		}
+
+		lenT = len(s1) + matchesT*(len(tk.ToStr(argAt(argsA, 2, "")))-len(oldT))
+	case 1553: // repeat
+		lenT = len(tk.ToStr(argAt(argsA, 0, ""))) * tk.ToInt(argAt(argsA, 1, 0), 0)
+	case 1555, 1556: // padLeft, padRight
+		lenT = tk.ToInt(argAt(argsA, 1, 0), 0) * len(tk.ToStr(argAt(argsA, 2, " ")))
+	}
+
+	if lenT > p.Limits.MaxStrLen {
+		return p.setLimitErr("MaxStrLen", lenT, p.Limits.MaxStrLen)
+	}
+
+	return nil
+}
+
+// checkRegInstrArgs checks the length of the string to be built by regReplace before building it
+func (p *VM) checkRegInstrArgs(codeA int, argsA []interface{}) error {
+	if p.Limits.MaxStrLen < 1 || codeA != 1621 {
+		return nil
+	}
+
+	regT, errT := p.GetRegexp(tk.ToStr(argAt(argsA, 1, "")))
+
+	if errT != nil {
+		// reported by EvalRegInstr
+		return nil
+	}
+
+	lenT := regReplaceLen(regT, tk.ToStr(argAt(argsA, 0, "")), tk.ToStr(argAt(argsA, 2, "")))
+
+	if lenT > p.Limits.MaxStrLen {
+		return p.setLimitErr("MaxStrLen", lenT, p.Limits.MaxStrLen)
+	}
+
+	return nil
+}
+
+// regReplaceLen gets the length of the result of regA.ReplaceAllString(strA, templateA) without building it
+func regReplaceLen(regA *regexp.Regexp, strA string, templateA string) int {
+	groupCountT := regA.NumSubexp() + 1
+
+	// expand the template with the empty groups to get the length of the literal part, then with each group of 1 byte to get the count of its references
+	indexesT := make([]int, groupCountT*2)
+
+	baseT := len(regA.ExpandString(nil, templateA, "x", indexesT))
+
+	refCountsT := make([]int, groupCountT)
+
+	for i := range refCountsT {
+		indexesT[2*i+1] = 1
+
+		refCountsT[i] = len(regA.ExpandString(nil, templateA, "x", indexesT)) - baseT
+
+		indexesT[2*i+1] = 0
+	}
+
+	lenT := len(strA)
+
+	for _, v := range regA.FindAllStringSubmatchIndex(strA, -1) {
+		lenT += baseT - (v[1] - v[0])
+
+		for i := 0; i < groupCountT; i++ {
+			if v[2*i] >= 0 {
+				lenT += refCountsT[i] * (v[2*i+1] - v[2*i])
+			}
+		}
+	}
+
+	return lenT
+}
+
+// checkLimits checks the resource limits after each instruction
+func (p *VM) checkLimits() error {
+	if errT := p.budget.limitErr.Load(); errT != nil {
+		return errT
+	}
+
+	if p.Limits.MaxStackDepth > 0 {
+		if p.Stack.Size() > p.Limits.MaxStackDepth {
+			return p.setLimitErr("MaxStackDepth", p.Stack.Size(), p.Limits.MaxStackDepth)
+		}
+
+		if p.InternalStack.Size() > p.Limits.MaxStackDepth {
+			return p.setLimitErr("MaxStackDepth", p.InternalStack.Size(), p.Limits.MaxStackDepth)
+		}
+	}
+
+	// the root function context is not counted
+	if p.Limits.MaxCallDepth > 0 && p.FuncStack.Size()-1 > p.Limits.MaxCallDepth {
+		return p.setLimitErr("MaxCallDepth", p.FuncStack.Size()-1, p.Limits.MaxCallDepth)
+	}
+
+	if p.Limits.MaxOutputBytes > 0 {
+		totalT := int(p.budget.outputBytes.Load())
+
+		if p.stdoutWriter != nil {
+			totalT += p.stdoutWriter.Buffered()
+		}
+
+		if p.stderrWriter != nil {
+			totalT += p.stderrWriter.Buffered()
+		}
+
+		if totalT > p.Limits.MaxOutputBytes {
+			return p.setLimitErr("MaxOutputBytes", totalT, p.Limits.MaxOutputBytes)
+		}
+	}
+
+	return nil
+}
+
+// ErrInstrLimitExceeded is the cause of CancelError while the instruction budget(MaxInstrs) is exhausted
+var ErrInstrLimitExceeded = errors.New("instruction limit exceeded")
 
-		if instrT == nil || tk.IsUndefined(instrT) {
-			break
-		}
+// CancelError is returned while the running is cancelled by the context(or timeout), or the instruction budget is exhausted, the deferred instructions are run before returning, and it could not be handled by the error handler in the script
+type CancelError struct {
+	Err error // context.Canceled, context.DeadlineExceeded or ErrInstrLimitExceeded
+}
 
-		nv, ok := instrT.(*Instr)
+func (e *CancelError) Error() string {
+	return fmt.Sprintf("running cancelled: %v", e.Err)
+}
 
-		if !ok {
-			nvv, ok := instrT.(Instr)
+func (e *CancelError) Unwrap() error {
+	return e.Err
+}
 
-			if ok {
-				nv = &nvv
-			} else {
-				return fmt.Errorf("invalid instruction: %#v", instrT)
-			}
-		}
+// the interval(count of instructions) to check the context
+const ctxCheckInterval = 1000
 
-		// if GlobalsG.VerboseLevel > 1 {
-		// 	tk.Pl("defer run: %v", nv)
-		// }
+// checkCancel counts the instruction and checks the instruction budget(shared with the child VMs) and the context(every ctxCheckInterval instructions of this VM)
+func (p *VM) checkCancel() error {
+	p.instrCount++
 
-		rs := RunInstr(vmA, nv)
+	if p.MaxInstrs > 0 && p.budget.instrCount.Add(1) > int64(p.MaxInstrs) {
+		return &CancelError{Err: ErrInstrLimitExceeded}
+	}
 
-		if tk.IsError(rs) {
-			return fmt.Errorf("[%v](xie) runtime error: %v", tk.GetNowTimeStringFormal(), tk.GetErrStrX(rs))
+	if p.instrCount%ctxCheckInterval == 0 {
+		select {
+		case <-p.Ctx.Done():
+			return &CancelError{Err: p.Ctx.Err()}
+		default:
 		}
 	}
 
@@ -2073,13 +6815,57 @@ func (p *VM) RunDeferUpToRoot() error {
 
 }
 
-func (p *VM) Run(posA ...int) interface{} {
+// Run runs the code with the context and the input value(as $inputG in the script), returns the output value(set by exit or exitCode) and the error if any
+func (p *VM) Run(ctxA context.Context, inputA interface{}) (interface{}, error) {
+	if ctxA != nil {
+		p.Ctx = ctxA
+	}
+
+	p.Regs[1] = inputA
+	p.SetGlobal("inputG", inputA)
+
+	var rs interface{}
+
+	if p.Code.IsDeepCompiled() {
+		rs = p.RunOpCodes()
+	} else {
+		rs = p.RunInstrs()
+	}
+
+	if errT, ok := rs.(error); ok {
+		return nil, errT
+	}
+
+	if nv, ok := rs.(string); ok && tk.IsErrStrX(nv) {
+		return nil, fmt.Errorf("%v", tk.GetErrStr(nv))
+	}
+
+	return p.Regs[2], nil
+}
+
+// RunInstrs runs the instructions(not the opcodes by DeepCompile), from posA if specified
+func (p *VM) RunInstrs(posA ...int) interface{} {
 	// tk.Pl("%#v", p)
+	p.UseOpCodes = false
+
+	p.running = true
+	defer func() {
+		p.running = false
+	}()
+
+	defer p.startTasks()()
+
+	defer p.Flush()
+	defer p.StopTraps()
+
 	p.CodePointer = 0
 	if len(posA) > 0 {
 		p.CodePointer = posA[0]
 	}
 
+	p.instrCount = 0
+	p.budget.reset()
+
 	if len(p.Code.InstrList) < 1 {
 		return tk.Undefined
 	}
@@ -2089,6 +6875,21 @@ func (p *VM) Run(posA ...int) interface{} {
 		// 	tk.Pl("-- RunInstr [%v] %v", p.Running.CodePointer, tk.LimitString(p.Running.Source[p.Running.CodeSourceMap[p.Running.CodePointer]], 50))
 		// }
 
+		if errT := p.CheckTraps(); errT != nil {
+			p.RunDeferUpToRoot()
+			return errT
+		}
+
+		if errT := p.checkCancel(); errT != nil {
+			p.RunDeferUpToRoot()
+			return errT
+		}
+
+		if errT := p.checkLimits(); errT != nil {
+			p.RunDeferUpToRoot()
+			return fmt.Errorf("[%v](qxlang) runtime error: %w", tk.GetNowTimeStringFormal(), errT)
+		}
+
 		resultT := RunInstr(p, &p.Code.InstrList[p.CodePointer])
 
 		c1T, ok := resultT.(int)
@@ -2096,6 +6897,11 @@ func (p *VM) Run(posA ...int) interface{} {
 		if ok {
 			p.CodePointer = c1T
 		} else {
+			if nv, ok := resultT.(*CancelError); ok {
+				p.RunDeferUpToRoot()
+				return nv
+			}
+
 			if tk.IsError(resultT) {
 				if p.ErrorHandler > -1 {
 					// p.SetVarGlobal("lastLineG", p.Running.CodeSourceMap[p.Running.CodePointer]+1)
@@ -2111,7 +6917,7 @@ func (p *VM) Run(posA ...int) interface{} {
 				}
 				// tk.Plo(1.2, p.Running, p.RootFunc)
 				p.RunDeferUpToRoot()
-				return fmt.Errorf("[%v](qxlang) runtime error: %v", tk.GetNowTimeStringFormal(), tk.GetErrStrX(resultT))
+				return fmt.Errorf("[%v](qxlang) runtime error: %w", tk.GetNowTimeStringFormal(), resultT.(error))
 				// tk.Pl("[%v](xie) runtime error: %v", tk.GetNowTimeStringFormal(), p.CodeSourceMapM[p.CodePointerM]+1, tk.GetErrStr(rs))
 				// break
 			}
@@ -2163,6 +6969,11 @@ func (p *VM) Run(posA ...int) interface{} {
 
 	}
 
+	if errT := p.checkLimits(); errT != nil {
+		p.RunDeferUpToRoot()
+		return fmt.Errorf("[%v](qxlang) runtime error: %w", tk.GetNowTimeStringFormal(), errT)
+	}
+
 	rsi := p.RunDeferUpToRoot()
 
 	if tk.IsErrX(rsi) {
@@ -2181,27 +6992,62 @@ func (p *VM) Run(posA ...int) interface{} {
 }
 
 func (p *ByteCode) DealInputParams(instrA *Instr, startA int) int {
-	var jvn VarRef
-
 	for i := instrA.ParamLen - 1; i >= startA; i-- {
-		jvn = instrA.Params[i]
-		switch jvn.Ref {
-		case -3: // value
-			p.Consts = append(p.Consts, jvn.Value)
+		p.DealInputParam(instrA.Params[i], instrA.SourceLine)
+	}
+
+	return instrA.ParamLen - startA
+}
+
+// DealInputParam generates the opcodes to push the value of the variable to the internal stack
+func (p *ByteCode) DealInputParam(jvn VarRef, sourceLineA int) {
+	switch jvn.Ref {
+	case -3: // value
+		p.Consts = append(p.Consts, jvn.Value)
+
+		p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpConst, ParamLen: 1, Params: []int{len(p.Consts) - 1}, SourceLine: sourceLineA})
+	case -7: // $peek
+		p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPeek, SourceLine: sourceLineA})
+		// p.OpCodeListToLineMap[len(p.OpCodeList)-1] = instrA.SourceLine
+	case -21: // array/slice item
+		nv := jvn.Value.([]interface{})
+
+		p.DealInputParam(nv[1].(VarRef), sourceLineA)
+		p.DealInputParam(nv[0].(VarRef), sourceLineA)
+
+		p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpGetItem, SourceLine: sourceLineA})
+	case -22: // map item
+		nv := jvn.Value.([]interface{})
 
-			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpConst, ParamLen: 1, Params: []int{len(p.Consts) - 1}, SourceLine: instrA.SourceLine})
-		case -7: // $peek
-			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPeek, SourceLine: instrA.SourceLine})
-			// p.OpCodeListToLineMap[len(p.OpCodeList)-1] = instrA.SourceLine
-		case -56: // integer label
-			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpValue, ParamLen: 1, Params: []int{jvn.Value.(int)}, SourceLine: instrA.SourceLine})
-		case 3: // local vars
-			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpGetLocalVarValue, ParamLen: 1, Params: []int{jvn.Value.(int)}, SourceLine: instrA.SourceLine})
+		p.DealInputParam(nv[1].(VarRef), sourceLineA)
+		p.DealInputParam(nv[0].(VarRef), sourceLineA)
+
+		p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpGetMapItem, SourceLine: sourceLineA})
+	case -56: // integer label, will be converted to the opcode index at the end of DeepCompile
+		p.undealtLabels = append(p.undealtLabels, len(p.OpCodeList))
+
+		p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpValue, ParamLen: 1, Params: []int{jvn.Value.(int)}, SourceLine: sourceLineA})
+	case -61: // interpolated string, lowered to concatenation
+		partsT := jvn.Value.([]VarRef)
+
+		for i := len(partsT) - 1; i >= 0; i-- {
+			p.DealInputParam(partsT[i], sourceLineA)
 		}
 
+		p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpStrAdd, ParamLen: 2, Params: []int{len(partsT), 1501}, SourceLine: sourceLineA})
+	case -11: // $seq
+		p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpSeq, SourceLine: sourceLineA})
+	case 3: // local vars
+		p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpGetLocalVarValue, ParamLen: 1, Params: []int{jvn.Value.(int)}, SourceLine: sourceLineA})
+	case 4: // global vars, the name is in the consts
+		p.Consts = append(p.Consts, jvn.Value)
+
+		p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpGetGlobalVarValue, ParamLen: 1, Params: []int{len(p.Consts) - 1}, SourceLine: sourceLineA})
+	default:
+		if p.dealErr == nil {
+			p.dealErr = fmt.Errorf("undealt var type(line %v: %v): %#v", sourceLineA, p.Source[sourceLineA], jvn)
+		}
 	}
-
-	return instrA.ParamLen - startA
 }
 
 // func (p *ByteCode) DealInputParamsToList(instrA *Instr, startA int) int {
@@ -2233,10 +7079,22 @@ func (p *ByteCode) DealOutputParams(instrA *Instr, startA int) error {
 		switch jvn.Ref {
 		case 3:
 			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpAssignLocal, ParamLen: 1, Params: []int{jvn.Value.(int)}, SourceLine: instrA.SourceLine})
+		case 4:
+			p.Consts = append(p.Consts, jvn.Value)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpAssignGlobal, ParamLen: 1, Params: []int{len(p.Consts) - 1}, SourceLine: instrA.SourceLine})
+		case -2: // $drop
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpDrop, SourceLine: instrA.SourceLine})
+		case -4: // $pln
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPln, ParamLen: 1, Params: []int{1}, SourceLine: instrA.SourceLine})
+		case -6: // $push
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPush, SourceLine: instrA.SourceLine})
 		default:
-			tk.Pl("undealt var type: %#v", jvn)
-			os.Exit(1)
-			return fmt.Errorf("undealt var type: %#v", jvn)
+			if p.dealErr == nil {
+				p.dealErr = fmt.Errorf("undealt var type(line %v: %v): %#v", instrA.SourceLine, p.Source[instrA.SourceLine], jvn)
+			}
+
+			return p.dealErr
 		}
 
 	}
@@ -2244,24 +7102,100 @@ func (p *ByteCode) DealOutputParams(instrA *Instr, startA int) error {
 	return nil
 }
 
-func plDebug(formatA string, argsA ...interface{}) {
-	if DebugG {
-		tk.Pl("[D] "+formatA, argsA...)
+// plDebug prints the debug information to the VM's Stderr directly(not counted in Limits.MaxOutputBytes), so the VMs running in parallel do not share the output
+func (p *VM) plDebug(formatA string, argsA ...interface{}) {
+	if p.Debug {
+		if p.stderrWriter != nil {
+			p.stderrWriter.Flush()
+		}
+
+		fmt.Fprintf(p.Stderr, "[D] "+formatA+"\n", argsA...)
+	}
+}
+
+func (p *ByteCode) plDebug(formatA string, argsA ...interface{}) {
+	if p.Debug {
+		writerT := p.debugWriter
+
+		if writerT == nil {
+			writerT = os.Stderr
+		}
+
+		fmt.Fprintf(writerT, "[D] "+formatA+"\n", argsA...)
 	}
 }
 
+// IsDeepCompiled checks if the code has been compiled to opcodes, to determine the engine to run it
+func (p *ByteCode) IsDeepCompiled() bool {
+	p.deepLock.Lock()
+	defer p.deepLock.Unlock()
+
+	return p.deepCompiled
+}
+
+// DeepCompileErr gets the error of DeepCompile(the reason why the code is run by instructions), nil if not failed
+func (p *ByteCode) DeepCompileErr() error {
+	p.deepLock.Lock()
+	defer p.deepLock.Unlock()
+
+	return p.deepErr
+}
+
+// DeepCompile compiles the instructions to opcodes, the opcodes are dropped if any instruction could not be lowered, and the code will be run by instructions
 func (p *ByteCode) DeepCompile() error {
+	p.deepLock.Lock()
+	defer p.deepLock.Unlock()
+
+	if p.deepCompiled {
+		return nil
+	}
+
+	if p.deepErr != nil {
+		return p.deepErr
+	}
+
+	errT := p.deepCompile()
+
+	if errT != nil {
+		p.deepErr = errT
+
+		p.Consts = make([]interface{}, 0)
+		p.OpCodeList = make([]OpCode, 0)
+
+		return errT
+	}
+
+	return nil
+}
+
+func (p *ByteCode) deepCompile() error {
+	p.Consts = make([]interface{}, 0)
 	p.OpCodeList = make([]OpCode, 0, len(p.InstrList))
 
 	// p.OpCodeListToLineMap = make(map[int]int)
 
 	deepLabelMapT := map[int]int{}
 
-	undealtLabelListT := [][]int{}
+	p.undealtLabels = make([]int, 0)
+
+	p.dealErr = nil
 
 	for i, v := range p.InstrList {
 		deepLabelMapT[i] = len(p.OpCodeList)
 
+		// check the permissions at runtime, the denied error is set to the result(the last opcode of the instruction) if possible
+		checkPermIndexT := -1
+
+		if permT, toResultT := p.getInstrPermission(&v); permT != PermNone {
+			checkPermIndexT = len(p.OpCodeList)
+
+			if !toResultT {
+				checkPermIndexT = -2
+			}
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpCheckPerm, ParamLen: 3, Params: []int{int(permT), -1, v.Code}, SourceLine: v.SourceLine})
+		}
+
 		switch v.Code {
 		case 1010: // call
 			lenT := p.DealInputParams(&v, 1)
@@ -2273,6 +7207,14 @@ func (p *ByteCode) DeepCompile() error {
 			lenT := p.DealInputParams(&v, 0)
 
 			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpRet, ParamLen: 1, Params: []int{lenT}, SourceLine: v.SourceLine})
+		case 198: // exitCode
+			if v.ParamLen < 1 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			p.DealInputParams(&v, 0)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpExitCode, SourceLine: v.SourceLine})
 		case 199: // exit
 			lenT := p.DealInputParams(&v, 0)
 
@@ -2290,39 +7232,271 @@ func (p *ByteCode) DeepCompile() error {
 		case 224: // pop
 			// p.DealInputParams(&v, 0)
 
-			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPop, SourceLine: v.SourceLine})
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPop, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+
+		case 180: // goto
+			p.DealInputParams(&v, 0)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpGoto, SourceLine: v.SourceLine})
+
+		case 401: // =
+			p.DealInputParams(&v, 1)
+
+			p.DealOutputParams(&v, 0)
+		case 1910: // now
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpNow, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 10410: // pln
+			// paramLenT := p.DealInputParamsToList(&v, 0)
+			paramLenT := p.DealInputParams(&v, 0)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPln, ParamLen: 1, Params: []int{paramLenT}, SourceLine: v.SourceLine})
+
+		// p.DealOutputParams(&v, 0)
+		case 10420: // pl
+			// paramLenT := p.DealInputParamsToList(&v, 0)
+			paramLenT := p.DealInputParams(&v, 0)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPl, ParamLen: 1, Params: []int{paramLenT}, SourceLine: v.SourceLine})
+
+			// p.DealOutputParams(&v, 0)
+		case 10401: // pr
+			paramLenT := p.DealInputParams(&v, 0)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPr, ParamLen: 1, Params: []int{paramLenT}, SourceLine: v.SourceLine})
+		case 10430: // plErr
+			paramLenT := p.DealInputParams(&v, 0)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPlErr, ParamLen: 1, Params: []int{paramLenT}, SourceLine: v.SourceLine})
+		case 10501, 10503, 10505, 10511: // input related
+			infoT := inputInstrInfoMapG[v.Code]
+
+			if v.ParamLen < infoT.ParamLen {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: infoT.Op, ParamLen: 2, Params: []int{lenT, v.Code}, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 10451: // spr
+			if v.ParamLen < 2 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			paramLenT := p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpSpr, ParamLen: 1, Params: []int{paramLenT}, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 1501, 1503, 1511, 1521, 1522, 1531, 1533, 1535, 1537, 1541, 1551, 1553, 1555, 1556, 1571, 1573, 1575: // string related
+			infoT := strInstrInfoMapG[v.Code]
+
+			if v.ParamLen < infoT.ParamLen {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: infoT.Op, ParamLen: 2, Params: []int{lenT, v.Code}, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 21101, 21103, 21105, 21111, 21113, 21201, 21203, 21205, 21211, 21301, 21303, 21305, 21311: // file related
+			infoT := fileInstrInfoMapG[v.Code]
+
+			if v.ParamLen < infoT.ParamLen {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: infoT.Op, ParamLen: 2, Params: []int{lenT, v.Code}, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 21901, 21903, 21905, 21907, 21911, 21913, 21921, 21922, 21925, 21927: // path related
+			infoT := pathInstrInfoMapG[v.Code]
+
+			if v.ParamLen < infoT.ParamLen {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: infoT.Op, ParamLen: 2, Params: []int{lenT, v.Code}, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 20501: // sleep
+			if v.ParamLen < 1 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			p.DealInputParams(&v, 0)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpSleep, SourceLine: v.SourceLine})
+		case 20301, 20303, 20305, 20311: // command-line related
+			infoT := argsInstrInfoMapG[v.Code]
+
+			if v.ParamLen < infoT.ParamLen {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: infoT.Op, ParamLen: 2, Params: []int{lenT, v.Code}, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 20505: // trap
+			if v.ParamLen < 2 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			p.DealInputParams(&v, 0)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpTrap, SourceLine: v.SourceLine})
+		case 20521, 20522, 20523, 20525, 20527: // env related
+			infoT := envInstrInfoMapG[v.Code]
+
+			if v.ParamLen < infoT.ParamLen {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: infoT.Op, ParamLen: 2, Params: []int{lenT, v.Code}, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 22111, 22113, 22115: // method, getField, setField
+			infoT := reflectInstrInfoMapG[v.Code]
+
+			if v.ParamLen < infoT.ParamLen {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: infoT.Op, ParamLen: 2, Params: []int{lenT, v.Code}, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 22101: // callGo
+			if v.ParamLen < 2 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpCallGo, ParamLen: 1, Params: []int{lenT}, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 20601: // systemCmd
+			if v.ParamLen < 2 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpSystemCmd, ParamLen: 1, Params: []int{lenT}, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 20611, 20613, 20615, 20617, 20619: // process related
+			infoT := processInstrInfoMapG[v.Code]
+
+			if v.ParamLen < infoT.ParamLen {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: infoT.Op, ParamLen: 2, Params: []int{lenT, v.Code}, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 1070: // range
+			if v.ParamLen < 2 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 0)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpRange, ParamLen: 1, Params: []int{lenT}, SourceLine: v.SourceLine})
+		case 20641, 20643: // go, wait
+			if v.ParamLen < 2 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 1)
+
+			opT := OpGo
+
+			if v.Code == 20643 {
+				opT = OpWait
+			}
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: opT, ParamLen: 2, Params: []int{lenT, v.Code}, SourceLine: v.SourceLine})
+
+			p.DealOutputParams(&v, 0)
+		case 20631: // pipe
+			if v.ParamLen < 2 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPipe, ParamLen: 1, Params: []int{lenT}, SourceLine: v.SourceLine})
 
 			p.DealOutputParams(&v, 0)
+		case 20603: // systemCmdEx
+			if v.ParamLen < 2 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
 
-		case 180: // goto
-			p.DealInputParams(&v, 0)
+			lenT := p.DealInputParams(&v, 1)
 
-			undealtLabelListT = append(undealtLabelListT, []int{len(p.OpCodeList) - 1, 0})
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpSystemCmdEx, ParamLen: 1, Params: []int{lenT}, SourceLine: v.SourceLine})
 
-			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpGoto, SourceLine: v.SourceLine})
+			p.DealOutputParams(&v, 0)
+		case 122: // testByText
+			if v.ParamLen < 2 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			lenT := p.DealInputParams(&v, 0)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpTestByText, ParamLen: 1, Params: []int{lenT}, SourceLine: v.SourceLine})
+		case 151: // isErr
+			if v.ParamLen < 2 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
 
-		case 401: // =
 			p.DealInputParams(&v, 1)
 
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpIsErr, SourceLine: v.SourceLine})
+
 			p.DealOutputParams(&v, 0)
-		case 1910: // now
-			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpNow, SourceLine: v.SourceLine})
+		case 152: // getErrStr
+			if v.ParamLen < 2 {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			p.DealInputParams(&v, 1)
+
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpGetErrStr, SourceLine: v.SourceLine})
 
 			p.DealOutputParams(&v, 0)
-		case 10410: // pln
-			// paramLenT := p.DealInputParamsToList(&v, 0)
-			paramLenT := p.DealInputParams(&v, 0)
+		case 1611, 1613, 1615, 1617, 1621, 1631: // regex related
+			infoT := regInstrInfoMapG[v.Code]
 
-			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPln, ParamLen: 1, Params: []int{paramLenT}, SourceLine: v.SourceLine})
+			if v.ParamLen < infoT.ParamLen {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
 
-		// p.DealOutputParams(&v, 0)
-		case 10420: // pl
-			// paramLenT := p.DealInputParamsToList(&v, 0)
-			paramLenT := p.DealInputParams(&v, 0)
+			lenT := p.DealInputParams(&v, 1)
 
-			p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpPl, ParamLen: 1, Params: []int{paramLenT}, SourceLine: v.SourceLine})
+			p.OpCodeList = append(p.OpCodeList, OpCode{Code: infoT.Op, ParamLen: 2, Params: []int{lenT, v.Code}, SourceLine: v.SourceLine})
 
-			// p.DealOutputParams(&v, 0)
+			p.DealOutputParams(&v, 0)
 		case 9999900101: // +i
 			p.DealInputParams(&v, 1)
 
@@ -2338,41 +7512,128 @@ func (p *ByteCode) DeepCompile() error {
 			p.DealOutputParams(&v, 0)
 
 		default:
-			return fmt.Errorf("unknown instr: %#v(line %v: %v)", v, v.SourceLine, p.Source[v.SourceLine])
+			hostInstrT := p.getHostInstr(v.Code)
+
+			if hostInstrT == nil {
+				return fmt.Errorf("unknown instr: %#v(line %v: %v)", v, v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			if v.ParamLen < hostInstrT.ParamLen() {
+				return fmt.Errorf("not enough parameters(line %v: %v)", v.SourceLine, p.Source[v.SourceLine])
+			}
+
+			if hostInstrT.Spec.NoResult {
+				lenT := p.DealInputParams(&v, 0)
+
+				p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpHostCall, ParamLen: 2, Params: []int{lenT, v.Code}, SourceLine: v.SourceLine})
+
+				p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpDrop, SourceLine: v.SourceLine})
+			} else {
+				lenT := p.DealInputParams(&v, 1)
+
+				p.OpCodeList = append(p.OpCodeList, OpCode{Code: OpHostCall, ParamLen: 2, Params: []int{lenT, v.Code}, SourceLine: v.SourceLine})
+
+				p.DealOutputParams(&v, 0)
+			}
+		}
+
+		if checkPermIndexT >= 0 {
+			p.OpCodeList[checkPermIndexT].Params[1] = len(p.OpCodeList) - 1
 		}
 	}
 
-	for i, v := range undealtLabelListT {
-		labelIndexT := p.OpCodeList[v[0]].Params[v[1]]
+	if p.dealErr != nil {
+		return p.dealErr
+	}
+
+	deepLabelMapT[len(p.InstrList)] = len(p.OpCodeList)
+
+	for i, v := range p.undealtLabels {
+		labelIndexT := p.OpCodeList[v].Params[0]
 
 		deepLabelIndexT, ok := deepLabelMapT[labelIndexT]
 
 		if !ok {
-			return fmt.Errorf("deep label index not found for %V: %v", i, labelIndexT)
+			return fmt.Errorf("deep label index not found for %v: %v", i, labelIndexT)
 		}
 
-		p.OpCodeList[v[0]].Params[v[1]] = deepLabelIndexT
+		p.OpCodeList[v].Params[0] = deepLabelIndexT
 	}
 
-	plDebug("Consts: %#v", p.Consts)
-	plDebug("OpCodeList: %v", tk.ToJSONX(p.OpCodeList, "-sort", "-indent"))
+	p.DeepLabelMap = deepLabelMapT
+
+	p.deepCompiled = true
+
+	p.plDebug("Consts: %#v", p.Consts)
+	p.plDebug("OpCodeList: %v", tk.ToJSONX(p.OpCodeList, "-sort", "-indent"))
 
 	return nil
 }
 
 func (p *VM) RunOpCodes() (resultR interface{}) {
-	p.CodePointer = 0
+	p.UseOpCodes = true
+
+	p.running = true
+	defer func() {
+		p.running = false
+	}()
+
+	defer p.startTasks()()
+
+	defer p.Flush()
+	defer p.StopTraps()
+
+	p.instrCount = 0
+	p.budget.reset()
+
+	resultR = p.runOpCodesFrom(0)
+
+	if errT := p.checkLimits(); errT != nil && !tk.IsError(resultR) {
+		resultR = p.Errf("[%v](qxlang) runtime error: %w", tk.GetNowTimeStringFormal(), errT)
+	}
+
+	rsi := p.RunDeferUpToRoot()
+
+	if rsi != nil && !tk.IsError(resultR) {
+		resultR = p.Errf("[%v](qxlang) runtime error: %v", tk.GetNowTimeStringFormal(), rsi)
+	}
+
+	return
+}
+
+// funcReturn is the result of runOpCodesFrom while a function called by CallFunc returns
+type funcReturn struct {
+	Value interface{}
+}
+
+func (p *VM) runOpCodesFrom(posA int) (resultR interface{}) {
+	p.CodePointer = posA
 
 	var opCodeT OpCode
 
 	for {
+		if errT := p.CheckTraps(); errT != nil {
+			resultR = errT
+			return
+		}
+
+		if errT := p.checkCancel(); errT != nil {
+			resultR = errT
+			return
+		}
+
+		if errT := p.checkLimits(); errT != nil {
+			resultR = p.Errf("[%v](qxlang) runtime error: %w", tk.GetNowTimeStringFormal(), errT)
+			return
+		}
+
 		opCodeT = p.Code.OpCodeList[p.CodePointer]
 
-		plDebug("run op: %v(%#v), line[%v]: %v", opCodeT.Code, opCodeT, opCodeT.SourceLine, p.Code.Source[opCodeT.SourceLine])
+		p.plDebug("run op: %v(%#v), line[%v]: %v", opCodeT.Code, opCodeT, opCodeT.SourceLine, p.Code.Source[opCodeT.SourceLine])
 
 		switch opCodeT.Code {
 		case OpRet:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			rs := p.PointerStack.Pop().(DeepCallStruct)
 
@@ -2407,12 +7668,16 @@ func (p *VM) RunOpCodes() (resultR interface{}) {
 			// 	p.SetVar(pr, tk.Undefined)
 			// }
 
-			return rs.ReturnPointer + 1
+			if rs.ReturnPointer < 0 { // called by CallFunc
+				return funcReturn{p.InternalStack.Pop()}
+			}
+
+			p.CodePointer = rs.ReturnPointer + 1
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 			continue
 		case OpCall:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			p.PointerStack.Push(DeepCallStruct{ReturnPointer: p.CodePointer}) // , ReturnRef: p.InternalStack.Pop().(OpCode)
 
@@ -2433,38 +7698,42 @@ func (p *VM) RunOpCodes() (resultR interface{}) {
 
 			p.CodePointer = opLabelT
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 			continue
 		case OpNow:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			p.InternalStack.Push(time.Now())
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 		case OpConst:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			p.InternalStack.Push(p.Code.Consts[opCodeT.Params[0]])
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 		case OpValue:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			p.InternalStack.Push(opCodeT.Params[0])
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 		case OpAssignLocal:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
-			p.GetCurrentFuncContext().Vars[opCodeT.Params[0]] = p.InternalStack.Pop()
+			valueT := p.InternalStack.Pop()
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.checkValue(valueT)
+
+			p.GetCurrentFuncContext().Vars[opCodeT.Params[0]] = valueT
+
+			p.plDebug("end stack: %#v", p.InternalStack)
 		case OpGetLocalVarValue:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			p.InternalStack.Push(p.GetCurrentFuncContext().Vars[opCodeT.Params[0]])
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 		// case OpDealArgsList:
 		// 	plDebug("start stack: %#v", p.InternalStack)
 
@@ -2481,54 +7750,345 @@ func (p *VM) RunOpCodes() (resultR interface{}) {
 		// 	plDebug("end stack: %#v", p.InternalStack)
 
 		case OpPush:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			valueT := p.InternalStack.Pop()
+
+			p.checkValue(valueT)
 
-			p.Stack.Push(p.InternalStack.Pop())
+			p.Stack.Push(valueT)
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 		case OpPop:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			p.InternalStack.Push(p.Stack.Pop())
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 		case OpPeek:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			p.InternalStack.Push(p.Stack.Peek())
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpAssignReg:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.Regs[opCodeT.Params[0]] = p.InternalStack.Pop()
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpExitCode:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.Regs[2] = tk.ToInt(p.InternalStack.Pop(), 1)
+
+			p.plDebug("end stack: %#v", p.InternalStack)
 		case OpExit:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			resultR = p.Regs[2]
 			return
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 		case OpGoto:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			labelT := p.InternalStack.Pop().(int)
 
 			if labelT >= 0 {
 				p.CodePointer = labelT
 
-				plDebug("end stack: %#v", p.InternalStack)
+				p.plDebug("end stack: %#v", p.InternalStack)
 				continue
 			}
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 
 			resultR = p.Errf("invalid label: %v", labelT)
 			return
 		case OpAddInt:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			p.InternalStack.Push(p.InternalStack.Pop().(int) + p.InternalStack.Pop().(int))
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpDrop:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.InternalStack.Pop()
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpStrAdd, OpStrLen, OpTrim, OpToUpper, OpToLower, OpContains, OpStartsWith, OpEndsWith, OpIndexOf, OpReplace, OpSubStr, OpRepeat, OpPadLeft, OpPadRight, OpSplit, OpSplitLines, OpJoin:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			// Params[0] is the argument count, Params[1] is the original instruction code
+			argsT := p.PopArgs(opCodeT.Params[0])
+
+			if errT := p.checkStrInstrArgs(opCodeT.Params[1], argsT); errT != nil {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %w", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, errT)
+				return
+			}
+
+			rs := EvalStrInstr(opCodeT.Params[1], argsT)
+
+			if tk.IsError(rs) {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %v", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, rs)
+				return
+			}
+
+			p.InternalStack.Push(rs)
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpRegMatch, OpRegFind, OpRegFindAll, OpRegFindGroups, OpRegReplace, OpRegSplit:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			argsT := p.PopArgs(opCodeT.Params[0])
+
+			if errT := p.checkRegInstrArgs(opCodeT.Params[1], argsT); errT != nil {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %w", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, errT)
+				return
+			}
+
+			rs := p.EvalRegInstr(opCodeT.Params[1], argsT)
+
+			if tk.IsError(rs) {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %v", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, rs)
+				return
+			}
+
+			p.InternalStack.Push(rs)
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpLoadText, OpSaveText, OpAppendText, OpLoadBytes, OpSaveBytes, OpFileExists, OpIsDir, OpFileInfo, OpListDir, OpRemoveFile, OpRenameFile, OpCopyFile, OpEnsureMakeDirs:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.InternalStack.Push(p.EvalFileInstr(opCodeT.Params[1], p.PopArgs(opCodeT.Params[0])))
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpJoinPath, OpGetFileBase, OpGetFileExt, OpGetFileDir, OpAbsPath, OpRelPath, OpGetCurrentDir, OpSetCurrentDir, OpGetHomeDir, OpGetTempDir:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.InternalStack.Push(EvalPathInstr(opCodeT.Params[1], p.PopArgs(opCodeT.Params[0])))
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpGetGlobalVarValue:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.InternalStack.Push(p.GetGlobal(tk.ToStr(p.Code.Consts[opCodeT.Params[0]])))
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpAssignGlobal:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			valueT := p.InternalStack.Pop()
+
+			p.checkValue(valueT)
+
+			p.SetGlobal(tk.ToStr(p.Code.Consts[opCodeT.Params[0]]), valueT)
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpSeq:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.InternalStack.Push(p.Seq.Get())
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpSleep:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			errT := p.Sleep(tk.ToFloat(p.InternalStack.Pop(), 0))
+
+			if errT != nil {
+				resultR = errT
+				return
+			}
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpReadLine, OpReadAll, OpReadLines, OpGetInput:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			rs := p.EvalInputInstr(opCodeT.Params[1], p.PopArgs(opCodeT.Params[0]))
+
+			if nv, ok := rs.(*CancelError); ok {
+				resultR = nv
+				return
+			}
+
+			p.InternalStack.Push(rs)
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpGetParam, OpGetSwitch, OpIfSwitchExists, OpParams:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			rs := p.EvalArgsInstr(opCodeT.Params[1], p.PopArgs(opCodeT.Params[0]))
+
+			if tk.IsError(rs) {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %v", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, rs)
+				return
+			}
+
+			p.InternalStack.Push(rs)
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpTrap:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			v1 := p.InternalStack.Pop()
+			v2 := p.InternalStack.Pop()
+
+			errT := p.Trap(tk.ToStr(v1), tk.ToInt(v2, -1))
+
+			if errT != nil {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %v", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, errT)
+				return
+			}
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpGetEnv, OpSetEnv, OpRemoveEnv, OpGetEnvList, OpExpandEnv:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.InternalStack.Push(EvalEnvInstr(opCodeT.Params[1], p.PopArgs(opCodeT.Params[0])))
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpSystemCmd:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			argsT := p.PopArgs(opCodeT.Params[0])
+
+			rs := p.SystemCmd(tk.ToStr(argsT[0]), toStrList(argsT[1:])...)
+
+			if nv, ok := rs.(*CancelError); ok {
+				resultR = nv
+				return
+			}
+
+			p.InternalStack.Push(rs)
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpSystemCmdEx:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			rs := p.SystemCmdEx(p.PopArgs(opCodeT.Params[0]))
+
+			if nv, ok := rs.(*CancelError); ok {
+				resultR = nv
+				return
+			}
+
+			p.InternalStack.Push(rs)
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpRange:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			argsT := p.PopArgs(opCodeT.Params[0])
+
+			pointerT := p.funcPointerOf(argsT[1])
+
+			if pointerT < 0 {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): invalid label: %v", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, argsT[1])
+				return
+			}
+
+			errT := p.Range(argsT[0], pointerT)
+
+			if errT != nil {
+				if _, ok := errT.(*CancelError); ok {
+					resultR = errT
+					return
+				}
+
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %v", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, errT)
+				return
+			}
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpGo, OpWait:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			rs, errT := p.EvalGoInstr(opCodeT.Params[1], p.PopArgs(opCodeT.Params[0]))
+
+			if errT != nil {
+				if _, ok := errT.(*CancelError); ok {
+					resultR = errT
+					return
+				}
+
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %v", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, errT)
+				return
+			}
+
+			p.InternalStack.Push(rs)
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpPipe:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			rs := p.Pipe(p.PopArgs(opCodeT.Params[0]))
+
+			if rs == ErrExit {
+				resultR = p.Regs[2]
+				return
+			}
+
+			p.InternalStack.Push(rs)
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpStartProcess:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.InternalStack.Push(p.StartProcess(p.PopArgs(opCodeT.Params[0])))
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpWaitProcess, OpKillProcess, OpReadProcessOutput, OpWriteProcessInput:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.InternalStack.Push(p.EvalProcessInstr(opCodeT.Params[1], p.PopArgs(opCodeT.Params[0])))
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpTestByText:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			errT := p.TestByText(p.PopArgs(opCodeT.Params[0]))
+
+			if errT != nil {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %v", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, errT)
+				return
+			}
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpIsErr:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.InternalStack.Push(isErrValue(p.InternalStack.Pop()))
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpGetErrStr:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.InternalStack.Push(tk.GetErrStrX(p.InternalStack.Pop()))
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpGetItem:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			v1 := p.InternalStack.Pop()
+			v2 := p.InternalStack.Pop()
+
+			p.InternalStack.Push(tk.GetArrayItem(v1, tk.ToInt(v2, 0)))
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpGetMapItem:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			v1 := p.InternalStack.Pop()
+			v2 := p.InternalStack.Pop()
+
+			p.InternalStack.Push(tk.GetMapItem(v1, v2))
+
+			p.plDebug("end stack: %#v", p.InternalStack)
 		case OpTimeSub:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			v1 := p.InternalStack.Pop().(time.Time)
 			v2 := p.InternalStack.Pop().(time.Time)
@@ -2537,9 +8097,9 @@ func (p *VM) RunOpCodes() (resultR interface{}) {
 
 			p.InternalStack.Push(v3.Seconds())
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 		case OpPln:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
 			lenT := opCodeT.Params[0] // p.InternalStack.Pop().(int)
 
@@ -2549,28 +8109,114 @@ func (p *VM) RunOpCodes() (resultR interface{}) {
 				listT[i] = p.InternalStack.Pop()
 			}
 
-			fmt.Println(listT...)
+			p.Pln(listT...)
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 		case OpPl:
-			plDebug("start stack: %#v", p.InternalStack)
+			p.plDebug("start stack: %#v", p.InternalStack)
 
-			lenT := opCodeT.Params[0] // p.InternalStack.Pop().(int)
+			p.Pln(sprintfArgs(p.PopArgs(opCodeT.Params[0])))
 
-			formatT := p.InternalStack.Pop().(string)
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpPr:
+			p.plDebug("start stack: %#v", p.InternalStack)
 
-			listT := make([]interface{}, lenT)
+			p.Print(sprintfArgs(p.PopArgs(opCodeT.Params[0])))
 
-			for i := 1; i < lenT; i++ {
-				listT[i] = p.InternalStack.Pop()
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpPlErr:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			p.PlErr(sprintfArgs(p.PopArgs(opCodeT.Params[0])))
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpSpr:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			argsT := p.PopArgs(opCodeT.Params[0])
+
+			if errT := p.checkStrInstrArgs(10451, argsT); errT != nil {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %w", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, errT)
+				return
+			}
+
+			p.InternalStack.Push(sprintfArgs(argsT))
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpCheckPerm:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			permT := Permission(opCodeT.Params[0]) &^ p.Permissions
+
+			if permT != PermNone {
+				errT := &PermissionError{Instr: GetInstrName(opCodeT.Params[2]), Perm: permT}
+
+				if opCodeT.Params[1] < 0 {
+					resultR = p.Errf("[%v](qxlang) runtime error(line %v): %w", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, errT)
+					return
+				}
+
+				// skip the instruction and set the error to the result
+				p.InternalStack.Push(errT)
+
+				p.CodePointer = opCodeT.Params[1]
+
+				p.plDebug("end stack: %#v", p.InternalStack)
+				continue
+			}
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpMethod, OpGetField, OpSetField:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			rs, errT := EvalReflectInstr(opCodeT.Params[1], p.PopArgs(opCodeT.Params[0]))
+
+			if errT != nil {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %v", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, errT)
+				return
+			}
+
+			p.InternalStack.Push(rs)
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpCallGo:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			argsT := p.PopArgs(opCodeT.Params[0])
+
+			rs, errT := p.CallGo(argsT[0], argsT[1:]...)
+
+			if errT != nil {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %v", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, errT)
+				return
+			}
+
+			p.InternalStack.Push(rs)
+
+			p.plDebug("end stack: %#v", p.InternalStack)
+		case OpHostCall:
+			p.plDebug("start stack: %#v", p.InternalStack)
+
+			hostInstrT := p.Code.getHostInstr(opCodeT.Params[1])
+
+			if hostInstrT == nil {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): unknown host instr: %v", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, opCodeT.Params[1])
+				return
+			}
+
+			rs, errT := hostInstrT.Handler(p, p.PopArgs(opCodeT.Params[0]))
+
+			if errT != nil {
+				resultR = p.Errf("[%v](qxlang) runtime error(line %v): %v", tk.GetNowTimeStringFormal(), opCodeT.SourceLine+1, errT)
+				return
 			}
 
-			fmt.Printf(formatT, listT...)
+			p.InternalStack.Push(rs)
 
-			plDebug("end stack: %#v", p.InternalStack)
+			p.plDebug("end stack: %#v", p.InternalStack)
 		}
 
-		plDebug("")
+		p.plDebug("")
 
 		p.CodePointer++
 
@@ -2579,34 +8225,84 @@ func (p *VM) RunOpCodes() (resultR interface{}) {
 		}
 	}
 
-	resultR = nil
+	// the same as RunInstrs, the exit code set by exitCode is returned without exit
+	resultR = p.Regs[2]
+
+	if resultR == nil {
+		resultR = tk.Undefined
+	}
+
 	return
 }
 
+// RunCode compiles and runs the script(by opcodes if deep compiled, otherwise by instructions), optsA could be -scriptPath=(the path of the script file, os.Args are treated as the qx executable, the script and the arguments of the script), -timeout=(seconds, float), -maxInstrs=(the instruction budget), -perms=(the permissions such as exec,fs-read, all by default) and -debug
 func RunCode(scriptA string, optsA ...string) interface{} {
-	compiledT, errT := Compile(scriptA)
+	permT := PermAll
 
-	if errT != nil {
-		return errT
+	if permStrT := tk.GetSwitch(optsA, "-perms=", ""); permStrT != "" {
+		var errT error
+
+		permT, errT = ParsePermissions(permStrT)
+
+		if errT != nil {
+			return errT
+		}
+	}
+
+	debugT := tk.IfSwitchExistsWhole(optsA, "-debug")
+
+	compileOptsT := []interface{}{permT}
+
+	if debugT {
+		compileOptsT = append(compileOptsT, "-debug")
 	}
 
-	errT = compiledT.DeepCompile()
+	compiledT, errT := Compile(scriptA, compileOptsT...)
 
 	if errT != nil {
 		return errT
 	}
 
-	if DebugG {
+	if debugT {
 		tk.Pl("compiled: %v", tk.ToJSONX(compiledT, "-sort", "-indent"))
 	}
 
 	// rsT := compiledT.Run()
 
-	vmT := NewVM(compiledT)
+	vmT := NewVM(compiledT, WithDebug(debugT))
+
+	timeoutT := tk.ToFloat(tk.GetSwitch(optsA, "-timeout=", "0"), 0)
+
+	if timeoutT > 0 {
+		ctxT, cancelT := context.WithTimeout(context.Background(), time.Duration(timeoutT*float64(time.Second)))
+		defer cancelT()
+
+		vmT.Ctx = ctxT
+	}
+
+	vmT.MaxInstrs = tk.ToInt(tk.GetSwitch(optsA, "-maxInstrs=", "0"), 0)
 
-	rsT := vmT.RunOpCodes()
+	vmT.Permissions = permT
+
+	scriptPathT := tk.GetSwitch(optsA, "-scriptPath=", "")
+
+	if scriptPathT != "" {
+		vmT.SetGlobal("scriptPathG", scriptPathT)
+		vmT.SetGlobal("scriptDirG", filepath.Dir(scriptPathT))
+
+		// run as the qx command line does, os.Args are the qx executable, the script and the arguments of the script
+		vmT.ArgsOffset = 2
+	}
+
+	var rsT interface{}
+
+	if compiledT.IsDeepCompiled() {
+		rsT = vmT.RunOpCodes()
+	} else {
+		rsT = vmT.RunInstrs()
+	}
 
-	if DebugG {
+	if debugT {
 		tk.Pl("VM: %v", tk.ToJSONX(vmT, "-sort", "-indent"))
 	}
 
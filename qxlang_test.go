@@ -0,0 +1,1385 @@
+package qxlang
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/topxeq/tk"
+)
+
+func TestRegexCacheBounded(t *testing.T) {
+	codeT, errT := Compile("regMatch $1 \"abc\" `^a` \nregMatch $2 \"abc\" $3\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	vmT := NewVM(codeT)
+
+	for i := 0; i < regexCacheSize*2; i++ {
+		if _, errT := vmT.GetRegexp(fmt.Sprintf("^a%v", i)); errT != nil {
+			t.Fatal(errT)
+		}
+	}
+
+	if n := vmT.regexLRU.list.Len(); n != regexCacheSize {
+		t.Errorf("expected %v cached patterns, got %v", regexCacheSize, n)
+	}
+
+	if _, ok := vmT.regexLRU.items["^a0"]; ok {
+		t.Errorf("the least recently used pattern should be dropped")
+	}
+
+	// the constant patterns are kept in the code, which is not changed by the VMs
+	if len(codeT.regexCache) != 1 || codeT.regexCache["^a"] == nil {
+		t.Errorf("unexpected constant patterns: %v", codeT.regexCache)
+	}
+
+	if regT, errT := vmT.GetRegexp("^a"); errT != nil || regT != codeT.regexCache["^a"] {
+		t.Errorf("the constant pattern should be used: %v", errT)
+	}
+}
+
+func TestParseCmdArgs(t *testing.T) {
+	codeT, errT := Compile("pass\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	vmT := NewVM(codeT)
+
+	cmdArgsT, optsT := vmT.ParseCmdArgs([]interface{}{"-cwd=/tmp", "-timeout=3", "grep", "-e", "-timeout=5"})
+
+	if fmt.Sprintf("%v", cmdArgsT) != "[grep -e -timeout=5]" {
+		t.Errorf("unexpected command: %v", cmdArgsT)
+	}
+
+	if optsT.Dir != "/tmp" || optsT.Timeout != 3 {
+		t.Errorf("unexpected options: %#v", optsT)
+	}
+
+	// the option values are literal
+	_, optsT = vmT.ParseCmdArgs([]interface{}{"-env=PS1=$HOME", "-stdin=$1", "env"})
+
+	if len(optsT.Env) != 1 || optsT.Env[0] != "PS1=$HOME" || optsT.Stdin != "$1" {
+		t.Errorf("unexpected options: %#v", optsT)
+	}
+}
+
+func TestSystemCmdExTimeoutWithChild(t *testing.T) {
+	codeT, errT := Compile("pass\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	startTimeT := time.Now()
+
+	// the background sleep keeps the output pipe open after the shell is killed
+	rs := NewVM(codeT).SystemCmdEx([]interface{}{"-timeout=0.2", "sh", "-c", "sleep 5 & sleep 5"})
+
+	if nv, ok := rs.(map[string]interface{}); !ok || nv["timeout"] != true {
+		t.Errorf("unexpected result: %#v", rs)
+	}
+
+	if d := time.Since(startTimeT); d > 3*time.Second {
+		t.Errorf("the timeout is not enforced: %v", d)
+	}
+}
+
+// runByEngines runs the script by opcodes and by instructions, returns the output of each run
+func runByEngines(t *testing.T, scriptA string, optsFuncA func() []VMOption) []string {
+	t.Helper()
+
+	codeT, errT := Compile(scriptA)
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	outputsT := make([]string, 0, 2)
+
+	for _, useOpCodesT := range []bool{true, false} {
+		var bufT bytes.Buffer
+
+		optsT := []VMOption{WithStdout(&bufT)}
+
+		if optsFuncA != nil {
+			optsT = append(optsT, optsFuncA()...)
+		}
+
+		vmT := NewVM(codeT, optsT...)
+
+		var rs interface{}
+
+		if useOpCodesT {
+			rs = vmT.RunOpCodes()
+		} else {
+			rs = vmT.RunInstrs()
+		}
+
+		if errT, ok := rs.(error); ok {
+			t.Fatalf("failed to run(opcodes: %v): %v", useOpCodesT, errT)
+		}
+
+		outputsT = append(outputsT, bufT.String())
+	}
+
+	return outputsT
+}
+
+func TestReadLinesRange(t *testing.T) {
+	scriptT := "readLine $1\npln $1\nreadLines $2\nrange $2 :onLine\npln \"done\"\nexit\n:onLine\npl \"%v: %v\" [$1,#i0] [$1,#i1]\nregMatch $3 [$1,#i1] `^[^.]`\nret $3\n"
+
+	outputsT := runByEngines(t, scriptT, func() []VMOption {
+		return []VMOption{WithStdin(strings.NewReader("head\na\nb\n.\nz\n"))}
+	})
+
+	for _, v := range outputsT {
+		if v != "head\n0: a\n1: b\n2: .\ndone\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+}
+
+func TestLineIteratorLazy(t *testing.T) {
+	countT := 0
+
+	iteratorT := NewLineIterator(func() (string, error) {
+		countT++
+
+		if countT > 3 {
+			return "", io.EOF
+		}
+
+		return fmt.Sprintf("line%v", countT), nil
+	})
+
+	if _, _, lineT := iteratorT.Next(); lineT != "line1" || countT != 1 {
+		t.Errorf("the lines should be read one by one, got %v after %v reads", lineT, countT)
+	}
+
+	if listT, errT := iteratorT.ReadAll(); len(listT) != 2 || errT != nil {
+		t.Errorf("unexpected remaining lines: %v(%v)", listT, errT)
+	}
+}
+
+func TestJoinLineIteratorErr(t *testing.T) {
+	iteratorT := NewLineIterator(func() (string, error) {
+		return "", io.ErrUnexpectedEOF
+	})
+
+	if rs := EvalStrInstr(1575, []interface{}{iteratorT, ","}); rs != io.ErrUnexpectedEOF {
+		t.Errorf("expected the read error, got %#v", rs)
+	}
+}
+
+func TestPipeStreamToLabel(t *testing.T) {
+	// the function stops reading after the first line, the endless command should be stopped
+	scriptT := "pipe $1 \"yes\" :first\npln {$1,output}\nexit\n:first\nrange [$1,#i0] :take\nret $firstG\n:take\n= $firstG [$1,#i1]\nret #bfalse\n"
+
+	outputsT := runByEngines(t, scriptT, func() []VMOption {
+		ctxT, cancelT := context.WithTimeout(context.Background(), 10*time.Second)
+		t.Cleanup(cancelT)
+
+		return []VMOption{WithContext(ctxT)}
+	})
+
+	for _, v := range outputsT {
+		if v != "y\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+}
+
+func TestPipeFailedStage(t *testing.T) {
+	optsFuncT := func() []VMOption {
+		ctxT, cancelT := context.WithTimeout(context.Background(), 10*time.Second)
+		t.Cleanup(cancelT)
+
+		return []VMOption{WithContext(ctxT)}
+	}
+
+	// the endless command is stopped and waited before the error of the function is returned
+	outputsT := runByEngines(t, "pipe $1 \"yes\" :fail\npln $1\nexit\n:fail\ncallGo $1 \"notBound\"\nret $1\n", optsFuncT)
+
+	for _, v := range outputsT {
+		if !strings.Contains(v, "failed to run stage 2") {
+			t.Errorf("expected the error of stage 2, got %q", v)
+		}
+	}
+
+	outputsT = runByEngines(t, "pipe $1 \"sh -c 'exit 3'\" \"cat\"\npln {$1,exitCodes}\n", optsFuncT)
+
+	for _, v := range outputsT {
+		if v != "[3 0]\n" {
+			t.Errorf("unexpected exit codes: %q", v)
+		}
+	}
+
+	// exit in a function stage exits the program
+	codeT, errT := Compile("pipe $1 \"yes\" :stop\npln \"not exited\"\nexit\n:stop\nexit #i3\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	for _, useOpCodesT := range []bool{true, false} {
+		var bufT bytes.Buffer
+
+		vmT := NewVM(codeT, append(optsFuncT(), WithStdout(&bufT))...)
+
+		var rs interface{}
+
+		if useOpCodesT {
+			rs = vmT.RunOpCodes()
+		} else {
+			rs = vmT.RunInstrs()
+		}
+
+		if rs != 3 || bufT.Len() > 0 {
+			t.Errorf("unexpected result(opcodes: %v): %#v, %q", useOpCodesT, rs, bufT.String())
+		}
+	}
+
+	// an int value is not taken as a function stage
+	outputsT = runByEngines(t, "= $2 #i0\npipe $1 \"echo a\" $2\nisErr $3 $1\npln $3\n", optsFuncT)
+
+	for _, v := range outputsT {
+		if v != "true\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+}
+
+func TestResetStopsTraps(t *testing.T) {
+	codeT, errT := Compile("pass\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	vmT := NewVM(codeT)
+
+	if errT := vmT.Trap("SIGHUP", 0); errT != nil {
+		t.Fatal(errT)
+	}
+
+	vmT.Reset()
+
+	if vmT.signalC != nil || vmT.Traps != nil {
+		t.Errorf("the signal notification should be stopped by Reset")
+	}
+}
+
+func TestTrapInCall(t *testing.T) {
+	codeT, errT := Compile("trap \"SIGHUP\" :onHup\ncall $2 :f\npln \"not trapped\"\nexit\n:f\n= $1 \"callee\"\ncallGo $3 \"hup\"\nsleep #f5\nret\n:onHup\npln \"trapped\" $1\nexit\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	for _, useOpCodesT := range []bool{true, false} {
+		var bufT bytes.Buffer
+
+		vmT := NewVM(codeT, WithStdout(&bufT), WithBinding("hup", func() error {
+			return syscall.Kill(os.Getpid(), syscall.SIGHUP)
+		}))
+
+		startTimeT := time.Now()
+
+		var rs interface{}
+
+		if useOpCodesT {
+			rs = vmT.RunOpCodes()
+		} else {
+			rs = vmT.RunInstrs()
+		}
+
+		if errT, ok := rs.(error); ok {
+			t.Fatalf("failed to run(opcodes: %v): %v", useOpCodesT, errT)
+		}
+
+		// the sleep is interrupted, and the handler runs with the top level variables
+		if d := time.Since(startTimeT); d > 3*time.Second {
+			t.Errorf("the sleep is not interrupted by the signal: %v", d)
+		}
+
+		if !strings.HasPrefix(bufT.String(), "trapped") || strings.Contains(bufT.String(), "callee") {
+			t.Errorf("unexpected output(opcodes: %v): %q", useOpCodesT, bufT.String())
+		}
+
+		if vmT.FuncStack.Size() != 1 {
+			t.Errorf("the function calls are not unwound: %v", vmT.FuncStack.Size())
+		}
+	}
+}
+
+func TestExitCodeWithoutExit(t *testing.T) {
+	codeT, errT := Compile("exitCode #i5\n= $1 #i1\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	if rs := NewVM(codeT).RunOpCodes(); rs != 5 {
+		t.Errorf("RunOpCodes: expected 5, got %#v", rs)
+	}
+
+	if rs := NewVM(codeT).RunInstrs(); rs != 5 {
+		t.Errorf("RunInstrs: expected 5, got %#v", rs)
+	}
+}
+
+func TestParamsWithArgs(t *testing.T) {
+	outputsT := runByEngines(t, "params $1 \"-port:int=8080 the port\" \"-verbose:bool verbose output\"\npln {$1,port} {$1,verbose} {$1,args}\n", func() []VMOption {
+		return []VMOption{WithArgs("-port=9090", "a.txt", "b.txt")}
+	})
+
+	for _, v := range outputsT {
+		if v != "9090 false [a.txt b.txt]\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+
+	// only the declared options are taken, and all the arguments after -- are kept
+	outputsT = runByEngines(t, "params $1 \"-port:int=8080 the port\" \"-verbose:bool verbose output\"\npln {$1,port} {$1,verbose} {$1,args}\n", func() []VMOption {
+		return []VMOption{WithArgs("-5", "--verbose", "-x=1", "--", "-port=1", "-verbose")}
+	})
+
+	for _, v := range outputsT {
+		if v != "8080 true [-5 -x=1 -port=1 -verbose]\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+}
+
+func TestParamsHelpWithoutArgs(t *testing.T) {
+	codeT, errT := Compile("params\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	if _, ok := codeT.GetParamsHelp(); ok {
+		t.Errorf("no options should be found")
+	}
+}
+
+func TestOutputWriters(t *testing.T) {
+	codeT, errT := Compile("pl \"a=%v\" #i1\nplErr \"oops\"\npln \"b\"\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	for _, useOpCodesT := range []bool{true, false} {
+		var stdoutT, stderrT bytes.Buffer
+
+		vmT := NewVM(codeT, WithStdout(&stdoutT), WithStderr(&stderrT))
+
+		if useOpCodesT {
+			vmT.RunOpCodes()
+		} else {
+			vmT.RunInstrs()
+		}
+
+		if stdoutT.String() != "a=1\nb\n" || stderrT.String() != "oops\n" {
+			t.Errorf("unexpected output(opcodes: %v): %q, %q", useOpCodesT, stdoutT.String(), stderrT.String())
+		}
+
+		// the output is buffered until a line ends or Flush
+		vmT.Print("c")
+
+		if stdoutT.String() != "a=1\nb\n" {
+			t.Errorf("the output should be buffered: %q", stdoutT.String())
+		}
+
+		vmT.Pln("d")
+
+		if stdoutT.String() != "a=1\nb\ncd\n" {
+			t.Errorf("the line should be flushed: %q", stdoutT.String())
+		}
+
+		vmT.Print("e")
+		vmT.Flush()
+
+		if stdoutT.String() != "a=1\nb\ncd\ne" {
+			t.Errorf("unexpected output after Flush: %q", stdoutT.String())
+		}
+	}
+}
+
+func TestRunWithInput(t *testing.T) {
+	codeT, errT := Compile("strAdd $1 $prefixG $inputG\n= $lastG $1\nexit $1\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	vmT := NewVM(codeT, WithGlobal("prefixG", "hello "))
+
+	for _, v := range []string{"tom", "jerry"} {
+		rs, errT := vmT.Run(context.Background(), v)
+
+		if errT != nil {
+			t.Fatal(errT)
+		}
+
+		if rs != "hello "+v || vmT.GetGlobal("lastG") != "hello "+v {
+			t.Errorf("unexpected result: %#v, %#v", rs, vmT.GetGlobal("lastG"))
+		}
+	}
+
+	vmT.SetGlobal("prefixG", "hi ")
+
+	if rs, _ := vmT.Run(context.Background(), "tom"); rs != "hi tom" {
+		t.Errorf("unexpected result after SetGlobal: %#v", rs)
+	}
+
+	// the global variables are cleared and the options are applied again
+	vmT.Reset()
+
+	if vmT.GetGlobal("lastG") != tk.Undefined || vmT.GetGlobal("prefixG") != "hello " {
+		t.Errorf("unexpected global variables after Reset: %#v, %#v", vmT.GetGlobal("lastG"), vmT.GetGlobal("prefixG"))
+	}
+}
+
+func TestRunError(t *testing.T) {
+	codeT, errT := Compile("callGo $1 \"notBound\"\nexit $1\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	rs, errT := NewVM(codeT).Run(context.Background(), nil)
+
+	if errT == nil || !strings.Contains(errT.Error(), "not bound") || rs != nil {
+		t.Errorf("expected the runtime error, got %#v, %v", rs, errT)
+	}
+}
+
+func TestVMOptions(t *testing.T) {
+	codeT, errT := Compile("readLine $1\ncallGo $2 \"upper\" $1\npln $2 $nameG\nplErr \"e\"\ngetParam $3 #i0\npln $3\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	var stdoutT, stderrT bytes.Buffer
+
+	ctxT, cancelT := context.WithCancel(context.Background())
+	defer cancelT()
+
+	vmT := NewVM(codeT, WithContext(ctxT), WithStdin(strings.NewReader("abc\n")), WithStdout(&stdoutT), WithStderr(&stderrT), WithArgs("a1"), WithGlobal("nameG", "tom"), WithBinding("upper", strings.ToUpper), WithMaxInstrs(100), WithPermissions(PermFsRead), WithLimits(Limits{MaxCallDepth: 10}), WithDebug(false))
+
+	if vmT.Ctx != ctxT || vmT.MaxInstrs != 100 || vmT.Permissions != PermFsRead || vmT.Limits.MaxCallDepth != 10 || vmT.Debug {
+		t.Errorf("the options are not applied: %#v", vmT)
+	}
+
+	if _, errT := vmT.Run(nil, nil); errT != nil {
+		t.Fatal(errT)
+	}
+
+	if stdoutT.String() != "ABC tom\na1\n" || stderrT.String() != "e\n" {
+		t.Errorf("unexpected output: %q, %q", stdoutT.String(), stderrT.String())
+	}
+}
+
+func TestCompileDebugWriter(t *testing.T) {
+	var bufT bytes.Buffer
+
+	if _, errT := Compile("pln \"a\"\n", "-debug", &bufT); errT != nil {
+		t.Fatal(errT)
+	}
+
+	if !strings.Contains(bufT.String(), "[D] compiling") || !strings.Contains(bufT.String(), "[D] OpCodeList") {
+		t.Errorf("unexpected debug output: %q", bufT.String())
+	}
+
+	bufT.Reset()
+
+	// the writer is only used with -debug
+	if _, errT := Compile("pln \"a\"\n", &bufT); errT != nil || bufT.Len() > 0 {
+		t.Errorf("unexpected debug output: %q(%v)", bufT.String(), errT)
+	}
+}
+
+func TestDeepCompileTwice(t *testing.T) {
+	codeT, errT := Compile("pln \"a\" #i1\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	opCodesT, constsT := len(codeT.OpCodeList), len(codeT.Consts)
+
+	if errT := codeT.DeepCompile(); errT != nil {
+		t.Fatal(errT)
+	}
+
+	if len(codeT.OpCodeList) != opCodesT || len(codeT.Consts) != constsT {
+		t.Errorf("DeepCompile should do nothing for the compiled code: %v/%v, %v/%v", len(codeT.OpCodeList), opCodesT, len(codeT.Consts), constsT)
+	}
+}
+
+var registerTestInstrsOnce sync.Once
+
+func registerTestInstrs(t *testing.T) {
+	registerTestInstrsOnce.Do(func() {
+		errT := RegisterInstr("testDouble", InstrSpec{ParamLen: 1}, func(vmA *VM, argsA []interface{}) (interface{}, error) {
+			if tk.ToInt(argsA[0], 0) < 0 {
+				return nil, fmt.Errorf("negative value: %v", argsA[0])
+			}
+
+			return tk.ToInt(argsA[0], 0) * 2, nil
+		})
+
+		if errT != nil {
+			t.Fatal(errT)
+		}
+
+		errT = RegisterInstr("testSay", InstrSpec{ParamLen: 1, NoResult: true, Perm: PermNetwork}, func(vmA *VM, argsA []interface{}) (interface{}, error) {
+			vmA.Pln("say", argsA[0])
+
+			return nil, nil
+		})
+
+		if errT != nil {
+			t.Fatal(errT)
+		}
+	})
+}
+
+func TestRegisterInstr(t *testing.T) {
+	registerTestInstrs(t)
+
+	if errT := RegisterInstr("testDouble", InstrSpec{}, func(vmA *VM, argsA []interface{}) (interface{}, error) { return nil, nil }); errT == nil {
+		t.Errorf("the duplicated registration should fail")
+	}
+
+	if errT := RegisterInstr("pln", InstrSpec{}, func(vmA *VM, argsA []interface{}) (interface{}, error) { return nil, nil }); errT == nil {
+		t.Errorf("the built-in instruction should not be overridden")
+	}
+
+	outputsT := runByEngines(t, "testDouble $1 #i21\ntestSay $1\n", nil)
+
+	for _, v := range outputsT {
+		if v != "say 42\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+
+	// the error of the handler is a runtime error
+	codeT, errT := Compile("testDouble $1 #i-1\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	if _, errT := NewVM(codeT).Run(context.Background(), nil); errT == nil || !strings.Contains(errT.Error(), "negative value") {
+		t.Errorf("expected the error of the handler, got %v", errT)
+	}
+
+	// the permissions declared by InstrSpec are checked
+	if _, errT := Compile("testSay \"a\"\n", PermFsRead); errT == nil {
+		t.Errorf("testSay needs the network permission")
+	}
+}
+
+func TestCallGo(t *testing.T) {
+	scriptT := "callGo $1 \"repeat\" \"ab\" \"3\"\npln $1\ncallGo $2 \"divmod\" #i7 #f2\npln $2\ncallGo $3 \"divmod\" #i7 #i0\nisErr $4 $3\npln $4 $3\ncallGo $5 \"join\" #L`[\"a\", \"b\"]` \"-\"\npln $5\ncallGo $8 \"uint\" \"12\"\npln $8\n"
+
+	outputsT := runByEngines(t, scriptT, func() []VMOption {
+		return []VMOption{
+			WithBinding("repeat", strings.Repeat),
+			WithBinding("join", strings.Join),
+			WithBinding("divmod", func(a int, b int) (int, int, error) {
+				if b == 0 {
+					return 0, 0, fmt.Errorf("divided by zero")
+				}
+
+				return a / b, a % b, nil
+			}),
+			WithBinding("uint", func(a uint8) uint8 {
+				return a + 1
+			}),
+		}
+	})
+
+	for _, v := range outputsT {
+		if v != "ababab\n[3 1]\ntrue divided by zero\na-b\n13\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+
+	// negative values are rejected instead of wrapping around
+	for _, v := range []interface{}{-1, "-1", int64(-1)} {
+		if _, errT := CallGoFunc(func(a uint) uint { return a }, v); errT == nil {
+			t.Errorf("the negative value %#v should be rejected", v)
+		}
+	}
+
+	if _, errT := CallGoFunc(func(a uint8) uint8 { return a }, 256); errT == nil {
+		t.Errorf("the value out of range should be rejected")
+	}
+
+	for _, v := range []interface{}{300, "-129", uint64(1 << 63)} {
+		if rs, errT := CallGoFunc(func(a int8) int8 { return a }, v); errT == nil {
+			t.Errorf("the value %#v out of range should be rejected, got %v", v, rs)
+		}
+	}
+
+	if rs, errT := CallGoFunc(func(a int8) int8 { return a }, "-128"); errT != nil || rs != int8(-128) {
+		t.Errorf("unexpected result: %v(%v)", rs, errT)
+	}
+}
+
+type testPerson struct {
+	Name string
+	age  int
+}
+
+func (p testPerson) Greet(greetingA string) string {
+	return greetingA + ", " + p.Name
+}
+
+func (p *testPerson) SetAge(ageA int) int {
+	p.age = ageA
+
+	return p.age
+}
+
+func TestMethodAndFields(t *testing.T) {
+	scriptT := "callGo $1 \"tom\"\nmethod $2 $1 \"Greet\" \"hi\"\nmethod $3 $1 \"SetAge\" #i3\npln $2 $3\ncallGo $4 \"jerry\"\nmethod $5 $4 \"SetAge\" #i5\nmethod $6 $4 \"Greet\" \"hello\"\npln $5 $6\nsetField $7 $4 \"Name\" \"J2\"\ngetField $8 $7 \"Name\"\ngetField $9 $4 \"Name\"\npln $8 $9\nsetField $7 $1 \"Name\" \"T2\"\ngetField $8 $1 \"Name\"\npln $8\n"
+
+	var tomT *testPerson
+
+	outputsT := runByEngines(t, scriptT, func() []VMOption {
+		tomT = &testPerson{Name: "Tom"}
+
+		return []VMOption{WithBinding("tom", tomT), WithBinding("jerry", testPerson{Name: "Jerry"})}
+	})
+
+	for _, v := range outputsT {
+		if v != "hi, Tom 3\n5 hello, Jerry\nJ2 Jerry\nT2\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+
+	// the method with the pointer receiver modifies the bound pointer
+	if tomT.age != 3 || tomT.Name != "T2" {
+		t.Errorf("the pointer should be modified: %#v", tomT)
+	}
+
+	if _, errT := GetField(tomT, "age"); errT == nil || !strings.Contains(errT.Error(), "unexported") {
+		t.Errorf("expected the unexported field error, got %v", errT)
+	}
+
+	if _, errT := SetField(tomT, "age", 1); errT == nil || !strings.Contains(errT.Error(), "unexported") {
+		t.Errorf("expected the unexported field error, got %v", errT)
+	}
+
+	if _, errT := CallMethod(tomT, "Missing"); errT == nil || !strings.Contains(errT.Error(), "method not found") {
+		t.Errorf("expected the method not found error, got %v", errT)
+	}
+
+	codeT, errT := Compile("callGo $1 \"tom\"\nmethod $2 $1 \"Missing\"\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	if _, errT := NewVM(codeT, WithBinding("tom", tomT)).Run(context.Background(), nil); errT == nil || !strings.Contains(errT.Error(), "method not found") {
+		t.Errorf("expected the runtime error of the missing method, got %v", errT)
+	}
+}
+
+func TestCallLabel(t *testing.T) {
+	codeT, errT := Compile("exit\n:add\n+i $3 [$1,#i0] [$1,#i1]\nret $3\n:fail\ncallGo $1 \"notBound\"\nret $1\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	vmT := NewVM(codeT)
+
+	for i := 0; i < 3; i++ {
+		rs, errT := vmT.CallLabel(context.Background(), ":add", i, 10)
+
+		if errT != nil {
+			t.Fatal(errT)
+		}
+
+		if rs != i+10 {
+			t.Errorf("unexpected result: %#v", rs)
+		}
+	}
+
+	if _, errT := vmT.CallLabel(context.Background(), "fail"); errT == nil || !strings.Contains(errT.Error(), "not bound") {
+		t.Errorf("expected the error of the function, got %v", errT)
+	}
+
+	if _, errT := vmT.CallLabel(context.Background(), "notFound"); errT == nil {
+		t.Errorf("expected the label not found error")
+	}
+
+	// the VM could still be used after the failed call
+	if rs, errT := vmT.CallLabel(context.Background(), "add", 1, 2); errT != nil || rs != 3 {
+		t.Errorf("unexpected result after the failed call: %#v, %v", rs, errT)
+	}
+
+	// called from a Go function in the running script
+	var vmT2 *VM
+
+	outputsT := runByEngines(t, "callGo $1 \"twice\" #i4\npln $1\nexit\n:double\n+i $2 [$1,#i0] [$1,#i0]\nret $2\n", func() []VMOption {
+		return []VMOption{WithBinding("twice", func(n int) (interface{}, error) {
+			return vmT2.CallLabel(nil, "double", n)
+		}), func(p *VM) {
+			vmT2 = p
+		}}
+	})
+
+	for _, v := range outputsT {
+		if v != "8\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+}
+
+func TestStopInfiniteLoop(t *testing.T) {
+	scriptT := ":loop\n= $1 #i1\ngoto :loop\n"
+
+	codeT, errT := Compile(scriptT)
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	for _, useOpCodesT := range []bool{true, false} {
+		runT := func(vmA *VM) interface{} {
+			if useOpCodesT {
+				return vmA.RunOpCodes()
+			}
+
+			return vmA.RunInstrs()
+		}
+
+		ctxT, cancelT := context.WithTimeout(context.Background(), 100*time.Millisecond)
+
+		rs := runT(NewVM(codeT, WithContext(ctxT)))
+
+		cancelT()
+
+		var cancelErrT *CancelError
+
+		if errT, ok := rs.(error); !ok || !errors.As(errT, &cancelErrT) || !errors.Is(errT, context.DeadlineExceeded) {
+			t.Errorf("expected the timeout(opcodes: %v), got %#v", useOpCodesT, rs)
+		}
+
+		rs = runT(NewVM(codeT, WithMaxInstrs(5000)))
+
+		if errT, ok := rs.(error); !ok || !errors.Is(errT, ErrInstrLimitExceeded) {
+			t.Errorf("expected the instruction limit error(opcodes: %v), got %#v", useOpCodesT, rs)
+		}
+
+		// the sleep is interrupted by the cancellation
+		codeT2, errT := Compile("sleep #f10\n")
+
+		if errT != nil {
+			t.Fatal(errT)
+		}
+
+		ctxT, cancelT = context.WithCancel(context.Background())
+
+		time.AfterFunc(50*time.Millisecond, cancelT)
+
+		startTimeT := time.Now()
+
+		rs = runT(NewVM(codeT2, WithContext(ctxT)))
+
+		if errT, ok := rs.(error); !ok || !errors.Is(errT, context.Canceled) || time.Since(startTimeT) > 5*time.Second {
+			t.Errorf("expected the cancellation(opcodes: %v), got %#v", useOpCodesT, rs)
+		}
+	}
+
+	if errT, _ := RunCode(scriptT, "-timeout=0.1").(error); !errors.Is(errT, context.DeadlineExceeded) {
+		t.Errorf("expected the timeout of RunCode, got %#v", errT)
+	}
+
+	if errT, _ := RunCode(scriptT, "-maxInstrs=5000").(error); !errors.Is(errT, ErrInstrLimitExceeded) {
+		t.Errorf("expected the instruction limit error of RunCode, got %#v", errT)
+	}
+}
+
+func TestStopBlockingInstrs(t *testing.T) {
+	startTimeT := time.Now()
+
+	if errT, _ := RunCode("systemCmd $1 \"sleep\" \"3600\"\n", "-timeout=0.5").(error); !errors.Is(errT, context.DeadlineExceeded) || time.Since(startTimeT) > 10*time.Second {
+		t.Errorf("expected the timeout of systemCmd, got %#v", errT)
+	}
+
+	codeT, errT := Compile("readLine $1\npln $1\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	for _, useOpCodesT := range []bool{true, false} {
+		readerT, writerT := io.Pipe()
+		defer writerT.Close()
+
+		ctxT, cancelT := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancelT()
+
+		vmT := NewVM(codeT, WithContext(ctxT), WithStdin(readerT), WithStdout(io.Discard))
+
+		var rs interface{}
+
+		if useOpCodesT {
+			rs = vmT.RunOpCodes()
+		} else {
+			rs = vmT.RunInstrs()
+		}
+
+		if errT, _ := rs.(error); !errors.Is(errT, context.DeadlineExceeded) {
+			t.Errorf("expected the timeout of readLine(opcodes: %v), got %#v", useOpCodesT, rs)
+		}
+	}
+
+	// the input read before the cancellation is kept
+	readerT, writerT := io.Pipe()
+	defer writerT.Close()
+
+	ctxT, cancelT := context.WithCancel(context.Background())
+
+	vmT := NewVM(codeT, WithContext(ctxT), WithStdin(readerT))
+
+	go func() {
+		writerT.Write([]byte("ab"))
+		cancelT()
+	}()
+
+	if _, errT := vmT.ReadLine(); !errors.Is(errT, context.Canceled) {
+		t.Errorf("expected the cancellation, got %v", errT)
+	}
+
+	vmT.Ctx = context.Background()
+
+	go writerT.Write([]byte("c\n"))
+
+	if lineT, errT := vmT.ReadLine(); lineT != "abc" || errT != nil {
+		t.Errorf("unexpected line: %q(%v)", lineT, errT)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	// the instructions not permitted are rejected by Compile
+	_, errT := Compile("systemCmd $1 \"ls\"\n", PermFsRead)
+
+	var permErrT *PermissionError
+
+	if !errors.As(errT, &permErrT) || permErrT.Perm != PermExec {
+		t.Errorf("expected the permission error of systemCmd, got %v", errT)
+	}
+
+	// the denied error is set to the result parameter at runtime
+	outputsT := runByEngines(t, "getEnv $1 \"HOME\"\nisErr $2 $1\npln $2\n", func() []VMOption {
+		return []VMOption{WithPermissions(PermNone)}
+	})
+
+	for _, v := range outputsT {
+		if v != "true\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+
+	// or raised as a runtime error if there is no result parameter
+	registerTestInstrs(t)
+
+	codeT, errT := Compile("testSay \"a\"\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	for _, useOpCodesT := range []bool{true, false} {
+		vmT := NewVM(codeT, WithPermissions(PermNone))
+
+		var rs interface{}
+
+		if useOpCodesT {
+			rs = vmT.RunOpCodes()
+		} else {
+			rs = vmT.RunInstrs()
+		}
+
+		errT, _ := rs.(error)
+
+		if !errors.As(errT, &permErrT) || permErrT.Perm != PermNetwork {
+			t.Errorf("expected the permission error of testSay(opcodes: %v), got %v", useOpCodesT, rs)
+		}
+	}
+
+	// trap needs the signal permission
+	scriptT := "trap \"SIGHUP\" :onHup\nexit\n:onHup\nexit\n"
+
+	if _, errT := Compile(scriptT, PermAll&^PermSignal); !errors.As(errT, &permErrT) || permErrT.Perm != PermSignal {
+		t.Errorf("expected the permission error of trap, got %v", errT)
+	}
+
+	if permT, errT := ParsePermissions("exec,signal"); errT != nil || permT != PermExec|PermSignal {
+		t.Errorf("unexpected permissions: %v(%v)", permT, errT)
+	}
+
+	codeT, errT = Compile(scriptT)
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	for _, useOpCodesT := range []bool{true, false} {
+		vmT := NewVM(codeT, WithPermissions(PermNone))
+
+		var rs interface{}
+
+		if useOpCodesT {
+			rs = vmT.RunOpCodes()
+		} else {
+			rs = vmT.RunInstrs()
+		}
+
+		errT, _ := rs.(error)
+
+		if !errors.As(errT, &permErrT) || permErrT.Perm != PermSignal || vmT.signalC != nil {
+			t.Errorf("expected the permission error of trap(opcodes: %v), got %v", useOpCodesT, rs)
+		}
+	}
+}
+
+func TestDeepCompileFallback(t *testing.T) {
+	scriptT := "= $1 #btrue\nif $1 :next\n:next\npln \"done\"\n"
+
+	// the code is run by instructions if any instruction could not be lowered
+	codeT, errT := Compile(scriptT)
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	if codeT.IsDeepCompiled() || codeT.DeepCompileErr() == nil {
+		t.Errorf("the code should not be deep compiled")
+	}
+
+	if errT := codeT.DeepCompile(); errT == nil || errT != codeT.DeepCompileErr() {
+		t.Errorf("expected the same deep compile error, got %v", errT)
+	}
+
+	var bufT bytes.Buffer
+
+	if _, errT := NewVM(codeT, WithStdout(&bufT)).Run(context.Background(), nil); errT != nil || bufT.String() != "done\n" {
+		t.Errorf("unexpected result: %v, %q", errT, bufT.String())
+	}
+
+	if rs := RunCode("= $1 #btrue\nif $1 :next\nexit #i1\n:next\nexit #i3\n"); rs != 3 {
+		t.Errorf("unexpected result of RunCode: %v", rs)
+	}
+
+	codeT, errT = Compile("pln \"done\"\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	if !codeT.IsDeepCompiled() || codeT.DeepCompileErr() != nil {
+		t.Errorf("the code should be deep compiled: %v", codeT.DeepCompileErr())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	fileT := filepath.Join(t.TempDir(), "long.txt")
+
+	if errT := os.WriteFile(fileT, []byte("abcdefgh"), 0666); errT != nil {
+		t.Fatal(errT)
+	}
+
+	casesT := []struct {
+		script string
+		limits Limits
+		stdin  string
+		limit  string
+	}{
+		{":loop\npush #i1\ngoto :loop\n", Limits{MaxStackDepth: 100}, "", "MaxStackDepth"},
+		{"call $1 :f\nexit\n:f\ncall $1 :f\nret $1\n", Limits{MaxCallDepth: 50}, "", "MaxCallDepth"},
+		{":loop\npln \"abc\"\ngoto :loop\n", Limits{MaxOutputBytes: 1000}, "", "MaxOutputBytes"},
+		{"strAdd $1 \"abc\" \"def\"\n", Limits{MaxStrLen: 5}, "", "MaxStrLen"},
+		{"join $1 #L`[\"abc\", \"def\"]` \",\"\n", Limits{MaxStrLen: 5}, "", "MaxStrLen"},
+		{"spr $1 \"%v-%v\" \"abc\" \"def\"\n", Limits{MaxStrLen: 5}, "", "MaxStrLen"},
+		{"readAll $1\n", Limits{MaxStrLen: 5}, "abcdefgh", "MaxStrLen"},
+		{"replace $1 \"aaaa\" \"a\" \"xxxx\"\n", Limits{MaxStrLen: 10}, "", "MaxStrLen"},
+		{"regReplace $1 \"abab\" \"(a)\" \"$1$1$1\"\n", Limits{MaxStrLen: 6}, "", "MaxStrLen"},
+		{"loadText $1 `" + fileT + "`\n", Limits{MaxStrLen: 5}, "", "MaxStrLen"},
+		{"loadBytes $1 `" + fileT + "`\n", Limits{MaxStrLen: 5}, "", "MaxStrLen"},
+		{"systemCmd $1 \"yes\"\n", Limits{MaxStrLen: 1000}, "", "MaxStrLen"},
+		{"systemCmdEx $1 \"yes\"\n", Limits{MaxStrLen: 1000}, "", "MaxStrLen"},
+		{"pipe $1 \"yes\"\n", Limits{MaxStrLen: 1000}, "", "MaxStrLen"},
+		{"= $1 #L`[1, 2, 3]`\n", Limits{MaxCollectionSize: 2}, "", "MaxCollectionSize"},
+	}
+
+	for _, c := range casesT {
+		codeT, errT := Compile(c.script)
+
+		if errT != nil {
+			t.Fatal(errT)
+		}
+
+		for _, useOpCodesT := range []bool{true, false} {
+			var bufT bytes.Buffer
+
+			vmT := NewVM(codeT, WithLimits(c.limits), WithStdin(strings.NewReader(c.stdin)), WithStdout(&bufT))
+
+			var rs interface{}
+
+			if useOpCodesT {
+				rs = vmT.RunOpCodes()
+			} else {
+				rs = vmT.RunInstrs()
+			}
+
+			errT, _ := rs.(error)
+
+			var limitErrT *LimitError
+
+			if !errors.As(errT, &limitErrT) || limitErrT.Limit != c.limit {
+				t.Errorf("expected the %v error(opcodes: %v, script: %q), got %v", c.limit, useOpCodesT, c.script, rs)
+			}
+
+			if c.limit == "MaxOutputBytes" && bufT.Len() > c.limits.MaxOutputBytes {
+				t.Errorf("the output is not truncated: %v", bufT.Len())
+			}
+		}
+	}
+
+	// the values within the limits
+	outputsT := runByEngines(t, "strAdd $1 \"ab\" \"cd\"\njoin $2 #L`[\"a\", \"b\"]` \",\"\nspr $3 \"%v%v\" $1 $2\npln $3\n", func() []VMOption {
+		return []VMOption{WithLimits(Limits{MaxStrLen: 12})}
+	})
+
+	for _, v := range outputsT {
+		if v != "abcda,b\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+}
+
+func TestRegReplaceLen(t *testing.T) {
+	casesT := []struct {
+		pattern  string
+		str      string
+		template string
+	}{
+		{"(a)", "abab", "$1$1$1"},
+		{"(?P<word>\\w+)-(\\d+)", "ab-12 cd-345 x", "${word}[$2]$$"},
+		{"x*", "abc", "-"},
+		{"b", "abc", "${1}z"},
+		{"(a)|(b)", "abc", "<$2>"},
+	}
+
+	for _, c := range casesT {
+		regT := regexp.MustCompile(c.pattern)
+
+		if lenT, expectedT := regReplaceLen(regT, c.str, c.template), len(regT.ReplaceAllString(c.str, c.template)); lenT != expectedT {
+			t.Errorf("unexpected length of %v: %v, expected %v", c, lenT, expectedT)
+		}
+	}
+}
+
+func TestLimitInReturnValue(t *testing.T) {
+	codeT, errT := Compile("exit\n:long\nret \"abcdef\"\n", "-noDeep")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	vmT := NewVM(codeT, WithLimits(Limits{MaxStrLen: 3}))
+
+	vmT.Stack.Push("keep")
+
+	_, errT = vmT.CallFunc(vmT.GetLabelIndex("long"))
+
+	var limitErrT *LimitError
+
+	if !errors.As(errT, &limitErrT) || limitErrT.Limit != "MaxStrLen" {
+		t.Errorf("expected the MaxStrLen error, got %v", errT)
+	}
+
+	// the data stack of the caller is not touched
+	if vmT.Stack.Size() != 1 || vmT.Stack.Peek() != "keep" {
+		t.Errorf("unexpected stack: %#v", vmT.Stack)
+	}
+}
+
+// run with -race to check the shared state of the VMs running in parallel
+func TestConcurrentVMs(t *testing.T) {
+	codeT, errT := Compile("strAdd $2 \"^\" $inputG\nregMatch $3 $inputG $2\nspr $4 \"%v-%v\" $inputG $3\npln $4\nexit $4\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+
+		go func(idxA int) {
+			defer wg.Done()
+
+			var stdoutT, stderrT bytes.Buffer
+
+			inputT := fmt.Sprintf("v%v", idxA)
+
+			vmT := NewVM(codeT, WithStdout(&stdoutT), WithStderr(&stderrT), WithGlobal("inputG", inputT), WithDebug(idxA%2 == 0))
+
+			var rs interface{}
+
+			if idxA%4 < 2 {
+				rs = vmT.RunOpCodes()
+			} else {
+				rs = vmT.RunInstrs()
+			}
+
+			vmT.Flush()
+
+			if rs != inputT+"-true" || stdoutT.String() != inputT+"-true\n" {
+				t.Errorf("unexpected result: %#v, %q", rs, stdoutT.String())
+			}
+
+			// the debug information(printed by the opcodes) goes to the VM's own stderr
+			if (stderrT.Len() > 0) != (idxA%4 == 0) || strings.Contains(stdoutT.String(), "[D]") {
+				t.Errorf("unexpected debug output: %q", stderrT.String())
+			}
+
+			_ = OpCodeNum(OpSpr).String()
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+func TestGoTasks(t *testing.T) {
+	scriptT := "go $1 :add #i1 #i2\ngo $2 :fail\ngo $3 :setGlobal\nwait $4 $1\nwait $5 $2\nwait $6 $3\nisErr $7 $5\npln $4 $7 $6 $sharedG\nexit\n:add\n+i $3 [$1,#i0] [$1,#i1]\nret $3\n:fail\ncallGo $1 \"notBound\"\nret $1\n:setGlobal\n= $sharedG \"set\"\nret \"ok\"\n"
+
+	outputsT := runByEngines(t, scriptT, nil)
+
+	for _, v := range outputsT {
+		if v != "3 true ok set\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+
+	// the tasks read lines from the same input
+	outputsT = runByEngines(t, "go $1 :read\ngo $2 :read\nwait $3 $1\nwait $4 $2\nstrAdd $5 $3 $4\npln $5\nexit\n:read\nreadLine $1\nret $1\n", func() []VMOption {
+		return []VMOption{WithStdin(strings.NewReader("a\nb\n"))}
+	})
+
+	for _, v := range outputsT {
+		if v != "ab\n" && v != "ba\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+}
+
+func TestGoSharedBudget(t *testing.T) {
+	codeT, errT := Compile("go $1 :print\ngo $2 :print\nwait $3 $1\nwait $4 $2\nexit\n:print\n" + strings.Repeat("pln \"abcd\"\n", 50) + "ret #i0\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	for _, useOpCodesT := range []bool{true, false} {
+		runT := func(optsA ...VMOption) (*VM, interface{}) {
+			vmT := NewVM(codeT, append([]VMOption{WithStdout(io.Discard)}, optsA...)...)
+
+			if useOpCodesT {
+				return vmT, vmT.RunOpCodes()
+			}
+
+			return vmT, vmT.RunInstrs()
+		}
+
+		// the instructions are counted only with a budget
+		vmT, rs := runT(WithMaxInstrs(1 << 30))
+
+		if errT, ok := rs.(error); ok {
+			t.Fatalf("failed to run: %v", errT)
+		}
+
+		totalT := int(vmT.budget.instrCount.Load())
+
+		// each task prints 250 bytes and runs less than half of the instructions, only exceeds the limits together
+		_, rs = runT(WithLimits(Limits{MaxOutputBytes: 300}))
+
+		var limitErrT *LimitError
+
+		if errT, _ := rs.(error); !errors.As(errT, &limitErrT) || limitErrT.Limit != "MaxOutputBytes" {
+			t.Errorf("expected the MaxOutputBytes error(opcodes: %v), got %v", useOpCodesT, rs)
+		}
+
+		_, rs = runT(WithMaxInstrs(totalT * 3 / 4))
+
+		if errT, _ := rs.(error); !errors.Is(errT, ErrInstrLimitExceeded) {
+			t.Errorf("expected the instruction limit error(opcodes: %v, total: %v), got %v", useOpCodesT, totalT, rs)
+		}
+	}
+}
+
+func TestRunStopsTasks(t *testing.T) {
+	codeT, errT := Compile("go $1 :loop\ngo $2 :sleep\nexit\n:loop\ngoto :loop\n:sleep\nsleep #i3600\nret #i1\n:start\ngo $1 :sleep\nret $1\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	for _, useOpCodesT := range []bool{true, false} {
+		vmT := NewVM(codeT)
+
+		startTimeT := time.Now()
+
+		if useOpCodesT {
+			vmT.RunOpCodes()
+		} else {
+			vmT.RunInstrs()
+		}
+
+		// the tasks are cancelled and waited before returning
+		if vmT.budget.tasks.Load() != 0 || time.Since(startTimeT) > 10*time.Second {
+			t.Errorf("the tasks should be stopped(opcodes: %v): %v", useOpCodesT, vmT.budget.tasks.Load())
+		}
+
+		if errT := vmT.Reset(); errT != nil {
+			t.Errorf("failed to reset: %v", errT)
+		}
+	}
+
+	// the same for the function called by the host
+	vmT := NewVM(codeT)
+
+	rs, errT := vmT.CallLabel(context.Background(), "start")
+
+	taskT, ok := rs.(*Task)
+
+	if errT != nil || !ok {
+		t.Fatalf("unexpected result: %#v, %v", rs, errT)
+	}
+
+	if _, errT := taskT.Wait(context.Background()); !errors.Is(errT, context.Canceled) {
+		t.Errorf("expected the cancelled task, got %v", errT)
+	}
+}
+
+func TestResetWithRunningTasks(t *testing.T) {
+	codeT, errT := Compile("exit\n:wait\nsleep #f0.2\nret #i1\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	vmT := NewVM(codeT)
+
+	taskT := vmT.Go(vmT.GetLabelIndex("wait"))
+
+	if errT := vmT.Reset(); !errors.Is(errT, ErrTasksRunning) {
+		t.Errorf("expected ErrTasksRunning, got %v", errT)
+	}
+
+	if rs, errT := taskT.Wait(context.Background()); errT != nil || rs != 1 {
+		t.Errorf("unexpected result: %#v, %v", rs, errT)
+	}
+
+	if errT := vmT.Reset(); errT != nil {
+		t.Errorf("failed to reset: %v", errT)
+	}
+}
+
+func TestIsErr(t *testing.T) {
+	outputsT := runByEngines(t, "isErr $1 \"TXERROR:x\"\nloadText $2 \"/nonexistent/_qxtest.txt\"\nisErr $3 $2\npln $1 $3\n", nil)
+
+	for _, v := range outputsT {
+		if v != "false true\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+}
+
+func TestGlobalNames(t *testing.T) {
+	outputsT := runByEngines(t, "= $nameG \"tom\"\npln $foo $nameG $G\n", nil)
+
+	for _, v := range outputsT {
+		if v != "$foo tom $G\n" {
+			t.Errorf("unexpected output: %q", v)
+		}
+	}
+}
+
+func TestProcessOutput(t *testing.T) {
+	codeT, errT := Compile("pass\n")
+
+	if errT != nil {
+		t.Fatal(errT)
+	}
+
+	vmT := NewVM(codeT)
+
+	// only the latest lines are kept if the output is not read
+	handleT, ok := vmT.StartProcess([]interface{}{"seq", "1", "20000"}).(*ProcessHandle)
+
+	if !ok {
+		t.Fatal("failed to start the process")
+	}
+
+	rs := vmT.EvalProcessInstr(20613, []interface{}{handleT, "-timeout=10"})
+
+	if nv, ok := rs.(map[string]interface{}); !ok || nv["exitCode"] != 0 || nv["dropped"] != 20000-lineQueueSize {
+		t.Errorf("unexpected result: %#v", rs)
+	}
+
+	if rs := vmT.EvalProcessInstr(20617, []interface{}{handleT}); rs != tk.ToStr(20000-lineQueueSize+1) {
+		t.Errorf("unexpected first line: %#v", rs)
+	}
+
+	// the output pipes held by a background child do not block waitProcess
+	startTimeT := time.Now()
+
+	handleT, ok = vmT.StartProcess([]interface{}{"sh", "-c", "echo hi; sleep 5 &"}).(*ProcessHandle)
+
+	if !ok {
+		t.Fatal("failed to start the process")
+	}
+
+	rs = vmT.EvalProcessInstr(20613, []interface{}{handleT, "-timeout=10"})
+
+	if nv, ok := rs.(map[string]interface{}); !ok || nv["exitCode"] != 0 {
+		t.Errorf("unexpected result: %#v", rs)
+	}
+
+	if d := time.Since(startTimeT); d > 3*time.Second {
+		t.Errorf("waitProcess is blocked by the child: %v", d)
+	}
+
+	if rs := vmT.EvalProcessInstr(20617, []interface{}{handleT}); rs != "hi" {
+		t.Errorf("unexpected output: %#v", rs)
+	}
+}